@@ -0,0 +1,276 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/mail"
+	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+	"github.com/jackc/pgx/v5"
+)
+
+// Email verification and password reset. Both mail the user a link with
+// a signed token (see utils.SignedToken); the token is also recorded in
+// email_tokens by hash, which is what makes it single-use.
+
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposePasswordReset = "password_reset"
+
+	verifyEmailTTL   = 48 * time.Hour
+	passwordResetTTL = time.Hour
+)
+
+// issueEmailToken creates and records a token for purpose
+func (h *UserHandler) issueEmailToken(ctx context.Context, userID int, purpose string, ttl time.Duration) (string, error) {
+	expires := h.now().Add(ttl)
+	token, err := utils.SignedToken(h.Secret, purpose, userID, expires)
+	if err != nil {
+		return "", err
+	}
+	_, err = h.DB.Exec(ctx,
+		`INSERT INTO email_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4)`,
+		userID, purpose, utils.HashToken(token), expires)
+	return token, err
+}
+
+// consumeEmailToken checks token and spends it in tx, returning its user
+func (h *UserHandler) consumeEmailToken(ctx context.Context, tx pgx.Tx, purpose, token string) (int, error) {
+	userID, err := utils.VerifySignedToken(h.Secret, purpose, token, h.now())
+	if err != nil {
+		return 0, err
+	}
+	tag, err := tx.Exec(ctx,
+		`UPDATE email_tokens SET used_at = NOW()
+		 WHERE token_hash=$1 AND purpose=$2 AND user_id=$3 AND used_at IS NULL`,
+		utils.HashToken(token), purpose, userID)
+	if err != nil {
+		return 0, err
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, utils.ErrTokenInvalid
+	}
+	return userID, nil
+}
+
+// appLink builds a link to a frontend page carrying token
+func (h *UserHandler) appLink(path, token string) string {
+	return strings.TrimSuffix(h.AppURL, "/") + path + "?token=" + token
+}
+
+// sendVerification mails userID a link to confirm email
+func (h *UserHandler) sendVerification(ctx context.Context, userID int, email string) error {
+	if h.Mailer == nil {
+		return nil
+	}
+	token, err := h.issueEmailToken(ctx, userID, purposeVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	return h.Mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Confirm your FileVault email address",
+		Body: "Open this link to confirm your email address:\n\n" +
+			h.appLink("/verify-email", token) + "\n\n" +
+			"The link expires in 48 hours. If you didn't sign up, ignore this email.\n",
+	})
+}
+
+// claimVerifiedInvites turns share invites for email into shares. Only a
+// proven address may claim them, or anyone could register someone else's
+// email and read what was shared with it.
+func (h *UserHandler) claimVerifiedInvites(ctx context.Context, userID int, email string) {
+	if err := claimInvites(ctx, h.DB, userID, email); err != nil {
+		log.Printf("⚠️ claiming share invites for user %d failed: %v", userID, err)
+	}
+}
+
+// POST /email/verify → confirm an email address ({"token"} from the mail)
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var userID int
+	var email string
+	err := pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		var err error
+		if userID, err = h.consumeEmailToken(r.Context(), tx, purposeVerifyEmail, req.Token); err != nil {
+			return err
+		}
+		return tx.QueryRow(r.Context(),
+			`UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id=$1
+			 RETURNING email`, userID,
+		).Scan(&email)
+	})
+	if errors.Is(err, utils.ErrTokenInvalid) || errors.Is(err, utils.ErrTokenExpired) {
+		http.Error(w, "❌ Invalid or expired link", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.claimVerifiedInvites(r.Context(), userID, email)
+	h.Audit.Log(r, AuditEvent{Action: AuditEmailVerify, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Email verified"})
+}
+
+// POST /email/verify/resend → mail a new verification link
+func (h *UserHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var email string
+	var verified bool
+	err := h.DB.QueryRow(r.Context(),
+		`SELECT email, email_verified_at IS NOT NULL FROM users WHERE id=$1`, userID,
+	).Scan(&email, &verified)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if verified {
+		http.Error(w, "❌ Email already verified", http.StatusConflict)
+		return
+	}
+	if err := h.sendVerification(r.Context(), userID, email); err != nil {
+		log.Printf("⚠️ sending verification mail to user %d failed: %v", userID, err)
+		http.Error(w, "❌ Could not send email", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Verification email sent"})
+}
+
+// POST /password/forgot → mail a reset link ({"email"}). The answer is
+// the same whether or not the address has an account.
+func (h *UserHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var userID int
+	var email string
+	err := h.DB.QueryRow(r.Context(),
+		`SELECT id, email FROM users WHERE lower(email)=lower($1) AND disabled_at IS NULL`, req.Email,
+	).Scan(&userID, &email)
+	if err != nil && err != pgx.ErrNoRows {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil && h.Mailer != nil {
+		token, err := h.issueEmailToken(r.Context(), userID, purposePasswordReset, passwordResetTTL)
+		if err == nil {
+			err = h.Mailer.Send(r.Context(), mail.Message{
+				To:      email,
+				Subject: "Reset your FileVault password",
+				Body: "Open this link to choose a new password:\n\n" +
+					h.appLink("/reset-password", token) + "\n\n" +
+					"The link expires in 1 hour and works once. If you didn't ask for this, ignore this email.\n",
+			})
+		}
+		if err != nil {
+			log.Printf("⚠️ sending password reset mail to user %d failed: %v", userID, err)
+		}
+		h.Audit.Log(r, AuditEvent{Action: AuditResetRequest, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ If that address has an account, a reset link is on its way"})
+}
+
+// POST /password/reset → set a new password ({"token", "password"}).
+// Every session of the account is logged out.
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.Password == "" {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+	resetFor, err := utils.VerifySignedToken(h.Secret, purposePasswordReset, req.Token, h.now())
+	if err != nil {
+		http.Error(w, "❌ Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+
+	var username, email string
+	err = h.DB.QueryRow(r.Context(),
+		`SELECT username, email FROM users WHERE id=$1`, resetFor).Scan(&username, &email)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ Invalid or expired link", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if fe := h.Breached.checkPassword(req.Password, username, email); fe != nil {
+		fieldErrors(w, http.StatusBadRequest, "Validation failed", []FieldError{*fe})
+		return
+	}
+
+	hashed, err := utils.HashPassword(req.Password)
+	if err != nil {
+		http.Error(w, "❌ Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	var userID int
+	err = pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		var err error
+		if userID, err = h.consumeEmailToken(r.Context(), tx, purposePasswordReset, req.Token); err != nil {
+			return err
+		}
+		// Getting the mail proves the address, too
+		if _, err := tx.Exec(r.Context(),
+			`UPDATE users SET password_hash=$2, sessions_revoked_at = $3,
+			        email_verified_at = COALESCE(email_verified_at, NOW()),
+			        failed_logins = 0, locked_until = NULL
+			 WHERE id=$1`, userID, hashed, utils.RevokedAt(time.Now())); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(r.Context(),
+			`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id=$1 AND revoked_at IS NULL`, userID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.Context(),
+			`UPDATE email_tokens SET used_at = NOW() WHERE user_id=$1 AND purpose=$2 AND used_at IS NULL`,
+			userID, purposePasswordReset)
+		return err
+	})
+	if errors.Is(err, utils.ErrTokenInvalid) {
+		http.Error(w, "❌ Invalid or expired link", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.claimVerifiedInvites(r.Context(), userID, email)
+	h.Audit.Log(r, AuditEvent{Action: AuditPasswordReset, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Password changed; log in with the new password"})
+}
@@ -0,0 +1,323 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AdminHandler serves the /admin endpoints; routes must be wrapped in
+// Auth.Admin
+type AdminHandler struct {
+	DB    *pgxpool.Pool
+	Files *FileHandler
+	Audit *Auditor
+}
+
+// AdminUser is a user account as listed for admins
+type AdminUser struct {
+	ID         int        `json:"id"`
+	Username   string     `json:"username"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	CreatedAt  *time.Time `json:"created_at"`
+	DisabledAt *time.Time `json:"disabled_at"`
+	UsedBytes  int64      `json:"used_bytes"`
+	QuotaBytes int64      `json:"quota_bytes"`
+	Files      int        `json:"files"`
+}
+
+// AdminFile is any user's file as listed for admins
+type AdminFile struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Owner      string     `json:"owner"`
+	Filename   string     `json:"filename"`
+	FileHash   string     `json:"file_hash"`
+	Size       int64      `json:"size"`
+	TotalSize  int64      `json:"total_size"`
+	MimeType   string     `json:"mime_type"`
+	UploadedAt time.Time  `json:"uploaded_at"`
+	IsDeleted  bool       `json:"is_deleted"`
+	DeletedAt  *time.Time `json:"deleted_at"`
+}
+
+// pageParams reads ?limit= (default 50, at most 500) and ?offset=
+func pageParams(r *http.Request) (limit, offset int) {
+	limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 50
+	} else if limit > 500 {
+		limit = 500
+	}
+	offset, _ = strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// targetUser parses {id} and checks the user exists
+func (h *AdminHandler) targetUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid user id", http.StatusBadRequest)
+		return 0, false
+	}
+	var exists bool
+	err = h.DB.QueryRow(r.Context(), `SELECT EXISTS (SELECT 1 FROM users WHERE id=$1)`, userID).Scan(&exists)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	if !exists {
+		http.Error(w, "❌ User not found", http.StatusNotFound)
+		return 0, false
+	}
+	return userID, true
+}
+
+// GET /admin/users → all accounts with their usage (?limit, ?offset)
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset := pageParams(r)
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT u.id, u.username, u.email, COALESCE(u.role, 'user'), u.created_at, u.disabled_at,
+		        COALESCE(s.used_bytes, 0), COALESCE(s.quota_bytes, 104857600),
+		        (SELECT COUNT(*) FROM files f WHERE f.user_id = u.id)::int
+		 FROM users u
+		 LEFT JOIN user_storage s ON s.user_id = u.id
+		 ORDER BY u.id
+		 LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	users, err := pgx.CollectRows(rows, pgx.RowToStructByPos[AdminUser])
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// GET /admin/users/{id}/storage → a user's storage usage
+func (h *AdminHandler) GetUserStorage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.targetUser(w, r)
+	if !ok {
+		return
+	}
+
+	used, quota, err := h.Files.userQuota(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "DB Error ("+err.Error()+")", http.StatusInternalServerError)
+		return
+	}
+
+	var files, trashed int
+	var trashBytes int64
+	err = h.DB.QueryRow(r.Context(),
+		`SELECT COUNT(*) FILTER (WHERE NOT is_deleted), COUNT(*) FILTER (WHERE is_deleted),
+		        COALESCE(SUM(COALESCE(total_size, size)) FILTER (WHERE is_deleted), 0)
+		 FROM files WHERE user_id=$1`, userID,
+	).Scan(&files, &trashed, &trashBytes)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_id":       userID,
+		"used_bytes":    used,
+		"quota_bytes":   quota,
+		"percent_used":  float64(used) / float64(quota) * 100,
+		"files":         files,
+		"trashed_files": trashed,
+		"trash_bytes":   trashBytes,
+	})
+}
+
+// PATCH /admin/users/{id}/quota → set a user's quota ({"quota_bytes"}).
+// Lowering it below current usage only blocks further uploads.
+func (h *AdminHandler) SetQuota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.targetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		QuotaBytes *int64 `json:"quota_bytes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.QuotaBytes == nil || *req.QuotaBytes < 0 {
+		http.Error(w, "❌ quota_bytes must be a non-negative number", http.StatusBadRequest)
+		return
+	}
+
+	_, oldQuota, err := h.Files.userQuota(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "DB Error ("+err.Error()+")", http.StatusInternalServerError)
+		return
+	}
+	_, err = h.DB.Exec(r.Context(),
+		`UPDATE user_storage SET quota_bytes = $2 WHERE user_id = $1`,
+		userID, *req.QuotaBytes)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.Audit.Log(r, AuditEvent{Action: AuditQuotaChange, TargetType: "user", TargetID: auditTarget(userID),
+		Details: map[string]interface{}{"from": oldQuota, "to": *req.QuotaBytes}})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":     "✅ Quota updated",
+		"user_id":     userID,
+		"quota_bytes": *req.QuotaBytes,
+	})
+}
+
+// POST /admin/users/{id}/disable → block an account; its tokens stop
+// working on the next request
+func (h *AdminHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, true)
+}
+
+// POST /admin/users/{id}/enable → unblock an account
+func (h *AdminHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, false)
+}
+
+func (h *AdminHandler) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	userID, ok := h.targetUser(w, r)
+	if !ok {
+		return
+	}
+	if adminID, _ := utils.GetUserID(r.Context()); disabled && adminID == userID {
+		http.Error(w, "❌ You can't disable your own account", http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.DB.Exec(r.Context(),
+		`UPDATE users SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) END WHERE id=$1`,
+		userID, disabled)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	msg, action := "✅ Account enabled", AuditUserEnable
+	if disabled {
+		msg, action = "✅ Account disabled", AuditUserDisable
+	}
+	h.Audit.Log(r, AuditEvent{Action: action, TargetType: "user", TargetID: auditTarget(userID)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": msg})
+}
+
+// POST /admin/users/{id}/mfa/reset → switch off a user's MFA, e.g. after
+// they lost both their device and recovery codes
+func (h *AdminHandler) ResetMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.targetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		return clearMFA(r.Context(), tx, userID)
+	})
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditMFAReset, TargetType: "user", TargetID: auditTarget(userID)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ MFA reset"})
+}
+
+// GET /admin/files → every user's files, trashed ones included
+// (?user_id, ?limit, ?offset)
+func (h *AdminHandler) ListFiles(w http.ResponseWriter, r *http.Request) {
+	limit, offset := pageParams(r)
+	var owner *int
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "❌ Invalid user_id", http.StatusBadRequest)
+			return
+		}
+		owner = &id
+	}
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT f.id, f.user_id, COALESCE(u.username, ''), f.filename, f.file_hash,
+		        COALESCE(f.size, 0), COALESCE(f.total_size, f.size, 0), COALESCE(f.mime_type, ''),
+		        f.uploaded_at, f.is_deleted, f.deleted_at
+		 FROM files f
+		 LEFT JOIN users u ON u.id = f.user_id
+		 WHERE $1::int IS NULL OR f.user_id = $1
+		 ORDER BY f.id DESC
+		 LIMIT $2 OFFSET $3`, owner, limit, offset)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	files, err := pgx.CollectRows(rows, pgx.RowToStructByPos[AdminFile])
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+// DELETE /admin/files/{id} → permanently delete any file, skipping the
+// trash, e.g. for abuse takedowns. Versions, shares and links go with it.
+func (h *AdminHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid file id", http.StatusBadRequest)
+		return
+	}
+
+	var ownerID int
+	var filename string
+	var stale []staleBlob
+	err = pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		err := tx.QueryRow(r.Context(),
+			`SELECT user_id, filename FROM files WHERE id=$1`, fileID).Scan(&ownerID, &filename)
+		if err == pgx.ErrNoRows {
+			return errFileNotFound
+		} else if err != nil {
+			return err
+		}
+		stale, err = h.Files.purgeFile(r.Context(), tx, fileID)
+		return err
+	})
+	if err == errFileNotFound {
+		http.Error(w, "❌ File not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "❌ Could not delete file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.Files.dropBlobs(r.Context(), stale)
+
+	h.Audit.Log(r, AuditEvent{Action: AuditAdminDelete, TargetType: "file", TargetID: auditTarget(fileID),
+		Details: map[string]interface{}{"owner_id": ownerID, "filename": filename}})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ File deleted permanently"})
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Scopes an API key can carry. Each route that accepts API keys names the
+// one it needs (see Auth.Scoped); all other routes want a login session.
+const (
+	ScopeFilesRead    = "files:read"
+	ScopeFilesWrite   = "files:write"
+	ScopeSharesManage = "shares:manage"
+)
+
+var apiKeyScopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeSharesManage}
+
+// apiKeyPrefix starts every key, so Auth can tell keys from JWTs and
+// secret scanners can spot leaked ones
+const apiKeyPrefix = "fv_"
+
+// APIKeyHandler lets users manage personal API keys for scripts and CI
+type APIKeyHandler struct {
+	DB    *pgxpool.Pool
+	Audit *Auditor
+}
+
+// APIKey is a key as listed for its owner. Key is only set in the
+// response that creates it; afterwards Prefix identifies it.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// normalizeScopes validates and de-duplicates requested scopes
+func normalizeScopes(scopes []string) ([]string, bool) {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, s := range scopes {
+		if !hasScope(apiKeyScopes, s) {
+			return nil, false
+		}
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out, len(out) > 0
+}
+
+// POST /apikeys → create a key ({"name", "scopes", "expires_at"}); the
+// key itself is only returned this once
+func (h *APIKeyHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "❌ name is required (at most 100 characters)", http.StatusBadRequest)
+		return
+	}
+	scopes, ok := normalizeScopes(req.Scopes)
+	if !ok {
+		http.Error(w, "❌ scopes must be a non-empty list of: "+strings.Join(apiKeyScopes, ", "), http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "❌ expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		http.Error(w, "❌ Could not create key", http.StatusInternalServerError)
+		return
+	}
+	key := APIKey{
+		Name:      req.Name,
+		Key:       apiKeyPrefix + secret,
+		Prefix:    apiKeyPrefix + secret[:8],
+		Scopes:    scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	err = h.DB.QueryRow(r.Context(),
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id, created_at`,
+		userID, key.Name, key.Prefix, utils.HashToken(key.Key), key.Scopes, key.ExpiresAt,
+	).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditAPIKeyCreate, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID),
+		Details: map[string]interface{}{"key_id": key.ID, "name": key.Name, "scopes": key.Scopes}})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// GET /apikeys → the user's active keys
+func (h *APIKeyHandler) GetKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT id, name, prefix, scopes, created_at, expires_at, last_used_at
+		 FROM api_keys
+		 WHERE user_id=$1 AND revoked_at IS NULL
+		 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt); err != nil {
+			http.Error(w, "Scan Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		keys = append(keys, k)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// DELETE /apikeys/{id} → revoke a key; it stops working immediately
+func (h *APIKeyHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tag, err := h.DB.Exec(r.Context(),
+		`UPDATE api_keys SET revoked_at = NOW()
+		 WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`,
+		mux.Vars(r)["id"], userID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "❌ API key not found", http.StatusNotFound)
+		return
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditAPIKeyRevoke, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID),
+		Details: map[string]interface{}{"key_id": mux.Vars(r)["id"]}})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ API key revoked"})
+}
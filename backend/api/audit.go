@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Audit actions
+const (
+	AuditLogin         = "auth.login"
+	AuditLoginFailed   = "auth.login_failed"
+	AuditAccountLocked = "auth.account_locked"
+	AuditRegister      = "auth.register"
+	AuditLogout        = "auth.logout"
+	AuditLogoutAll     = "auth.logout_all"
+	AuditTokenReuse    = "auth.refresh_reuse"
+	AuditMFAEnable     = "auth.mfa_enable"
+	AuditMFADisable    = "auth.mfa_disable"
+	AuditAPIKeyCreate  = "auth.apikey_create"
+	AuditAPIKeyRevoke  = "auth.apikey_revoke"
+	AuditEmailVerify   = "auth.email_verify"
+	AuditResetRequest  = "auth.password_forgot"
+	AuditPasswordReset = "auth.password_reset"
+	AuditUpload        = "file.upload"
+	AuditUploadVersion = "file.upload_version"
+	AuditPromote       = "file.promote_version"
+	AuditTrash         = "file.trash"
+	AuditRestore       = "file.restore"
+	AuditPurge         = "file.purge"
+	AuditFolderDelete  = "folder.delete"
+	AuditShareGrant    = "share.grant"
+	AuditShareUpdate   = "share.update"
+	AuditShareRevoke   = "share.revoke"
+	AuditMemberAdd     = "group.member_add"
+	AuditMemberRole    = "group.member_role"
+	AuditMemberRemove  = "group.member_remove"
+	AuditLinkCreate    = "link.create"
+	AuditLinkRevoke    = "link.revoke"
+	AuditQuotaChange   = "admin.quota_change"
+	AuditUserDisable   = "admin.user_disable"
+	AuditUserEnable    = "admin.user_enable"
+	AuditMFAReset      = "admin.mfa_reset"
+	AuditAdminDelete   = "admin.file_delete"
+)
+
+// auditGenesis is the prev_hash of the first entry
+const auditGenesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// auditLockKey serializes appends so every entry chains onto the last one
+const auditLockKey = 0x617564697400 // "audit"
+
+// AuditEvent is one thing worth recording. ActorID defaults to the
+// authenticated user of the request.
+type AuditEvent struct {
+	Action     string
+	ActorID    *int
+	TargetType string
+	TargetID   string
+	Details    map[string]interface{}
+}
+
+// AuditEntry is a stored audit_log row
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ActorID    *int      `json:"actor_id"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetID   string    `json:"target_id"`
+	IP         string    `json:"ip"`
+	Details    string    `json:"details"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// computeHash is the chain link: SHA-256 over the previous hash and every
+// field of the entry, so changing, removing or reordering rows breaks
+// the chain from that point on
+func (e *AuditEntry) computeHash() string {
+	actor := ""
+	if e.ActorID != nil {
+		actor = strconv.Itoa(*e.ActorID)
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%d\n%s\n%s\n%s\n%s\n%s\n%s\n%s",
+		e.PrevHash, e.ID, e.CreatedAt.UTC().Format(time.RFC3339Nano), actor,
+		e.Action, e.TargetType, e.TargetID, e.IP, e.Details)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Auditor appends to the hash-chained audit_log table. The table rejects
+// UPDATE and DELETE, and each row's hash covers the one before it. A nil
+// Auditor records nothing.
+//
+// The chain alone can't show that its newest entries were cut off, so
+// when Anchor is set the id and hash of every new entry are also written
+// to that file; keep it somewhere the database's owner can't rewrite.
+type Auditor struct {
+	DB      *pgxpool.Pool
+	Proxies TrustedProxies
+	Anchor  string
+
+	anchorMu sync.Mutex
+	anchored int64 // id last written to Anchor
+}
+
+// auditAnchor pins an entry of the chain: "<id>:<hash>"
+type auditAnchor struct {
+	ID   int64
+	Hash string
+}
+
+func (a auditAnchor) String() string {
+	return strconv.FormatInt(a.ID, 10) + ":" + a.Hash
+}
+
+func parseAuditAnchor(s string) (auditAnchor, error) {
+	id, hash, ok := strings.Cut(strings.TrimSpace(s), ":")
+	n, err := strconv.ParseInt(id, 10, 64)
+	if !ok || err != nil || n <= 0 || len(hash) != len(auditGenesis) {
+		return auditAnchor{}, fmt.Errorf("invalid anchor (want <id>:<hash>)")
+	}
+	return auditAnchor{ID: n, Hash: hash}, nil
+}
+
+// readAnchor loads the anchor file; ok is false if there is none yet
+func (a *Auditor) readAnchor() (auditAnchor, bool, error) {
+	if a == nil || a.Anchor == "" {
+		return auditAnchor{}, false, nil
+	}
+	b, err := os.ReadFile(a.Anchor)
+	if os.IsNotExist(err) {
+		return auditAnchor{}, false, nil
+	} else if err != nil {
+		return auditAnchor{}, false, err
+	}
+	anchor, err := parseAuditAnchor(string(b))
+	if err != nil {
+		return auditAnchor{}, false, fmt.Errorf("%s: %w", a.Anchor, err)
+	}
+	return anchor, true, nil
+}
+
+// writeAnchor replaces the anchor file with e, unless a newer entry has
+// already been written there
+func (a *Auditor) writeAnchor(e auditAnchor) error {
+	a.anchorMu.Lock()
+	defer a.anchorMu.Unlock()
+	if e.ID <= a.anchored {
+		return nil
+	}
+	tmp := a.Anchor + ".tmp"
+	if err := os.WriteFile(tmp, []byte(e.String()+"\n"), 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, a.Anchor); err != nil {
+		return err
+	}
+	a.anchored = e.ID
+	return nil
+}
+
+// Log records e for request r. Failures are logged, not returned: the
+// action being audited has already happened.
+func (a *Auditor) Log(r *http.Request, e AuditEvent) {
+	if a == nil {
+		return
+	}
+	if e.ActorID == nil {
+		if id, ok := utils.GetUserID(r.Context()); ok {
+			e.ActorID = &id
+		}
+	}
+	// Don't lose the entry if the client disconnects
+	ctx := context.WithoutCancel(r.Context())
+	entry, err := a.append(ctx, e, a.Proxies.ClientIP(r))
+	if err != nil {
+		log.Printf("⚠️ audit %s failed: %v", e.Action, err)
+		return
+	}
+	if a.Anchor != "" {
+		if err := a.writeAnchor(auditAnchor{ID: entry.ID, Hash: entry.Hash}); err != nil {
+			log.Printf("⚠️ audit anchor write failed: %v", err)
+		}
+	}
+}
+
+func (a *Auditor) append(ctx context.Context, e AuditEvent, ip string) (AuditEntry, error) {
+	var entry AuditEntry
+	details := "{}"
+	if len(e.Details) > 0 {
+		b, err := json.Marshal(e.Details)
+		if err != nil {
+			return entry, err
+		}
+		details = string(b)
+	}
+
+	err := pgx.BeginFunc(ctx, a.DB, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, auditLockKey); err != nil {
+			return err
+		}
+		entry = AuditEntry{
+			// Postgres keeps microseconds; hash what will be read back
+			CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+			ActorID:    e.ActorID,
+			Action:     e.Action,
+			TargetType: e.TargetType,
+			TargetID:   e.TargetID,
+			IP:         ip,
+			Details:    details,
+			PrevHash:   auditGenesis,
+		}
+		err := tx.QueryRow(ctx, `SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&entry.PrevHash)
+		if err != nil && err != pgx.ErrNoRows {
+			return err
+		}
+		if err := tx.QueryRow(ctx, `SELECT nextval('audit_log_id_seq')`).Scan(&entry.ID); err != nil {
+			return err
+		}
+		entry.Hash = entry.computeHash()
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO audit_log (id, created_at, actor_id, action, target_type, target_id, ip, details, prev_hash, hash)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			entry.ID, entry.CreatedAt, entry.ActorID, entry.Action, entry.TargetType, entry.TargetID,
+			entry.IP, entry.Details, entry.PrevHash, entry.Hash)
+		return err
+	})
+	return entry, err
+}
+
+// auditTarget formats an id for AuditEvent.TargetID
+func auditTarget(id int) string {
+	return strconv.Itoa(id)
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// auditFilter builds the WHERE clause for GET /admin/audit from ?actor_id,
+// ?action, ?target_type, ?target_id, ?since and ?until (RFC 3339)
+func auditFilter(r *http.Request) (string, []interface{}, error) {
+	q := r.URL.Query()
+	var conds []string
+	var args []interface{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if v := q.Get("actor_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid actor_id")
+		}
+		add("actor_id = $%d", id)
+	}
+	if v := q.Get("action"); v != "" {
+		// "share." matches every share action
+		if strings.HasSuffix(v, ".") {
+			add("starts_with(action, $%d)", v)
+		} else {
+			add("action = $%d", v)
+		}
+	}
+	if v := q.Get("target_type"); v != "" {
+		add("target_type = $%d", v)
+	}
+	if v := q.Get("target_id"); v != "" {
+		add("target_id = $%d", v)
+	}
+	for _, p := range []struct{ name, cond string }{{"since", "created_at >= $%d"}, {"until", "created_at < $%d"}} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid %s (use RFC 3339)", p.name)
+			}
+			add(p.cond, t)
+		}
+	}
+
+	if len(conds) == 0 {
+		return "", nil, nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+// GET /admin/audit → query the audit log, newest first. ?format=csv or
+// ?format=jsonl exports every matching entry (unless ?limit is given);
+// the default JSON response is paginated with ?limit and ?offset.
+func (h *AdminHandler) GetAudit(w http.ResponseWriter, r *http.Request) {
+	where, args, err := auditFilter(r)
+	if err != nil {
+		http.Error(w, "❌ "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" && format != "jsonl" {
+		http.Error(w, "❌ format must be json, csv or jsonl", http.StatusBadRequest)
+		return
+	}
+	query := `SELECT id, created_at, actor_id, action, target_type, target_id, ip, details, prev_hash, hash
+	          FROM audit_log ` + where + ` ORDER BY id DESC`
+	if format == "" || format == "json" || r.URL.Query().Get("limit") != "" {
+		limit, offset := pageParams(r)
+		args = append(args, limit, offset)
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+
+	rows, err := h.DB.Query(r.Context(), query, args...)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var e AuditEntry
+	scan := func() error {
+		return rows.Scan(&e.ID, &e.CreatedAt, &e.ActorID, &e.Action, &e.TargetType, &e.TargetID,
+			&e.IP, &e.Details, &e.PrevHash, &e.Hash)
+	}
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "created_at", "actor_id", "action", "target_type", "target_id", "ip", "details", "prev_hash", "hash"})
+		for rows.Next() {
+			if err := scan(); err != nil {
+				return // headers are out; all we can do is stop
+			}
+			actor := ""
+			if e.ActorID != nil {
+				actor = strconv.Itoa(*e.ActorID)
+			}
+			cw.Write([]string{strconv.FormatInt(e.ID, 10), e.CreatedAt.UTC().Format(time.RFC3339Nano), actor,
+				e.Action, e.TargetType, e.TargetID, e.IP, e.Details, e.PrevHash, e.Hash})
+		}
+		cw.Flush()
+
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		enc := json.NewEncoder(w)
+		for rows.Next() {
+			if err := scan(); err != nil {
+				return
+			}
+			enc.Encode(e)
+		}
+
+	default:
+		entries := []AuditEntry{}
+		for rows.Next() {
+			if err := scan(); err != nil {
+				http.Error(w, "Scan Error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			entries = append(entries, e)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// GET /admin/audit/verify → walk the whole chain and report the first
+// entry whose hash or link to its predecessor doesn't check out. Entries
+// cut off the end leave a valid chain, so it is also held against the
+// anchor file and an ?anchor=<id>:<hash> kept from an earlier run.
+func (h *AdminHandler) VerifyAudit(w http.ResponseWriter, r *http.Request) {
+	var anchors []auditAnchor
+	if v := r.URL.Query().Get("anchor"); v != "" {
+		a, err := parseAuditAnchor(v)
+		if err != nil {
+			http.Error(w, "❌ "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		anchors = append(anchors, a)
+	}
+	if a, ok, err := h.Audit.readAnchor(); err != nil {
+		http.Error(w, "❌ Could not read audit anchor: "+err.Error(), http.StatusInternalServerError)
+		return
+	} else if ok {
+		anchors = append(anchors, a)
+	}
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT id, created_at, actor_id, action, target_type, target_id, ip, details, prev_hash, hash
+		 FROM audit_log ORDER BY id`)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	result := map[string]interface{}{"ok": true}
+	prev := auditGenesis
+	var head int64
+	seen := make([]bool, len(anchors))
+	n := 0
+	for rows.Next() {
+		e, err := pgx.RowToStructByPos[AuditEntry](rows)
+		if err != nil {
+			http.Error(w, "Scan Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		n++
+		reason := ""
+		if e.PrevHash != prev {
+			reason = "entry before it was changed or removed"
+		} else if e.Hash != e.computeHash() {
+			reason = "entry was modified"
+		}
+		for i, a := range anchors {
+			if a.ID == e.ID {
+				if a.Hash != e.Hash {
+					reason = "entry does not match the anchor"
+				}
+				seen[i] = true
+			}
+		}
+		if reason != "" {
+			result = map[string]interface{}{"ok": false, "broken_at": e.ID, "reason": reason}
+			break
+		}
+		prev, head = e.Hash, e.ID
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result["ok"] == true {
+		for i, a := range anchors {
+			if !seen[i] {
+				result = map[string]interface{}{"ok": false, "broken_at": a.ID, "reason": "anchored entry was removed"}
+				break
+			}
+		}
+	}
+	result["checked"] = n
+	result["head"] = prev
+	result["head_id"] = head
+	if result["ok"] == true && head > 0 {
+		result["anchor"] = auditAnchor{ID: head, Hash: prev}.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseAuditAnchor(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	a, err := parseAuditAnchor(" 42:" + hash + "\n")
+	if err != nil || a.ID != 42 || a.Hash != hash {
+		t.Fatalf("got %+v, %v", a, err)
+	}
+	if got := a.String(); got != "42:"+hash {
+		t.Fatalf("String() = %q", got)
+	}
+	for _, bad := range []string{"", "42", "42:", "x:" + hash, "0:" + hash, "-1:" + hash, "42:" + hash[:10]} {
+		if _, err := parseAuditAnchor(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestAuditAnchorFile(t *testing.T) {
+	a := &Auditor{Anchor: filepath.Join(t.TempDir(), "audit.anchor")}
+	if _, ok, err := a.readAnchor(); ok || err != nil {
+		t.Fatalf("missing file: ok=%v err=%v", ok, err)
+	}
+
+	newer := auditAnchor{ID: 7, Hash: strings.Repeat("7", 64)}
+	older := auditAnchor{ID: 6, Hash: strings.Repeat("6", 64)}
+	for _, e := range []auditAnchor{newer, older} {
+		if err := a.writeAnchor(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An append that commits late must not move the anchor backwards
+	got, ok, err := a.readAnchor()
+	if err != nil || !ok || got != newer {
+		t.Fatalf("anchor = %+v ok=%v err=%v, want %+v", got, ok, err, newer)
+	}
+
+	// Without a file configured there is nothing to check against
+	if _, ok, err := (&Auditor{}).readAnchor(); ok || err != nil {
+		t.Fatalf("no anchor configured: ok=%v err=%v", ok, err)
+	}
+	var nilAuditor *Auditor
+	if _, ok, err := nilAuditor.readAnchor(); ok || err != nil {
+		t.Fatalf("nil auditor: ok=%v err=%v", ok, err)
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies whose X-Forwarded-For and
+// X-Real-IP headers we believe. Requests from anywhere else are
+// identified by their socket address, so clients can't spoof their IP.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma separated list of IPs and CIDRs
+func ParseTrustedProxies(list string) (TrustedProxies, error) {
+	var t TrustedProxies
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		t = append(t, n)
+	}
+	return t, nil
+}
+
+func (t TrustedProxies) trusted(ip net.IP) bool {
+	for _, n := range t {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client behind any trusted proxies.
+// X-Forwarded-For is walked from the right, skipping trusted hops, so
+// entries the client prepended itself are ignored.
+func (t TrustedProxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !t.trusted(ip) {
+		return host
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			if !t.trusted(hop) || i == 0 {
+				return hop.String()
+			}
+		}
+	}
+	if real := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); real != nil {
+		return real.String()
+	}
+	return host
+}
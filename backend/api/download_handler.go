@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// countingWriter records the status and body bytes of a response
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (c *countingWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// downloadSource says who fetched a file and how; UserID is nil for
+// public links, LinkID is set for them, Version for old versions
+type downloadSource struct {
+	UserID  *int
+	LinkID  *int
+	Version *int
+}
+
+// serveDownload is serveBlob plus a row in downloads for every request
+// that sent content (full or partial), with the bytes actually written.
+// The row keeps the file's name and owner so it outlives the file.
+// It reports whether content was sent, and how many body bytes.
+func (h *FileHandler) serveDownload(w http.ResponseWriter, r *http.Request, fileID int, m blobMeta, src downloadSource) (served bool, sent int64) {
+	cw := &countingWriter{ResponseWriter: w}
+	h.serveBlob(cw, r, m)
+	if r.Method == http.MethodHead || (cw.status != http.StatusOK && cw.status != http.StatusPartialContent) {
+		return false, 0
+	}
+
+	// Record even if the client hung up mid-transfer
+	ctx := context.WithoutCancel(r.Context())
+	_, err := h.DB.Exec(ctx,
+		`INSERT INTO downloads (file_id, downloaded_at, downloader_ip, user_id, link_id, version, user_agent, bytes_served, status,
+		                        filename, owner_id)
+		 VALUES ($1, NOW(), $2, $3, $4, $5, $6, $7, $8, $9, (SELECT user_id FROM files WHERE id=$1))`,
+		fileID, h.Proxies.ClientIP(r), src.UserID, src.LinkID, src.Version,
+		r.UserAgent(), cw.n, cw.status, m.Filename)
+	if err != nil {
+		log.Printf("⚠️ recording download of file %d failed: %v", fileID, err)
+	}
+	return true, cw.n
+}
+
+// Download is one recorded download of a file
+type Download struct {
+	ID           int       `json:"id"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+	IP           string    `json:"ip"`
+	UserID       *int      `json:"user_id"`
+	Username     *string   `json:"username"`
+	LinkID       *int      `json:"link_id"`
+	Version      *int      `json:"version"`
+	UserAgent    string    `json:"user_agent"`
+	BytesServed  int64     `json:"bytes_served"`
+	Status       int       `json:"status"`
+}
+
+// GET /files/{id}/downloads → who downloaded the owner's file, newest
+// first (?limit, ?offset)
+func (h *FileHandler) GetDownloads(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fileID, ok := h.ownedFile(w, r, userID)
+	if !ok {
+		return
+	}
+	limit, offset := pageParams(r)
+
+	var total int
+	var totalBytes int64
+	err := h.DB.QueryRow(r.Context(),
+		`SELECT COUNT(*), COALESCE(SUM(bytes_served), 0) FROM downloads WHERE file_id=$1`, fileID,
+	).Scan(&total, &totalBytes)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT d.id, d.downloaded_at, COALESCE(d.downloader_ip, ''), d.user_id, u.username,
+		        d.link_id, d.version, COALESCE(d.user_agent, ''), COALESCE(d.bytes_served, 0),
+		        COALESCE(d.status, 200)
+		 FROM downloads d
+		 LEFT JOIN users u ON u.id = d.user_id
+		 WHERE d.file_id=$1
+		 ORDER BY d.downloaded_at DESC, d.id DESC
+		 LIMIT $2 OFFSET $3`, fileID, limit, offset)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	downloads := []Download{}
+	for rows.Next() {
+		var d Download
+		if err := rows.Scan(&d.ID, &d.DownloadedAt, &d.IP, &d.UserID, &d.Username,
+			&d.LinkID, &d.Version, &d.UserAgent, &d.BytesServed, &d.Status); err != nil {
+			http.Error(w, "Scan Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		downloads = append(downloads, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"file_id":     fileID,
+		"total":       total,
+		"total_bytes": totalBytes,
+		"downloads":   downloads,
+	})
+}
@@ -1,17 +1,18 @@
 package api
 
 import (
-	"crypto/sha256"
-	"database/sql"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Dashsouradeep/balkanid-filevault/backend/models"
+	"github.com/Dashsouradeep/balkanid-filevault/backend/storage"
 	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
 	"github.com/gorilla/mux"
 	"github.com/jackc/pgx/v5"
@@ -22,10 +23,50 @@ import (
 type FileHandler struct {
 	DB     *pgxpool.Pool
 	Secret string
+	Store  storage.Backend
+
+	MaxUploadSize int64  // per-request cap in bytes, 0 = quota only
+	TempDir       string // where uploads are spooled, "" = os.TempDir()
+	MIMEPolicy    string // MIMEPolicyOff, MIMEPolicyWarn or MIMEPolicyReject
+
+	// Trash: entries are purged TrashRetention after deletion. With
+	// TrashCountsQuota trashed bytes stay in used_bytes until purged;
+	// otherwise they are released on delete and re-charged on restore.
+	TrashRetention   time.Duration
+	TrashCountsQuota bool
+
+	// Version history: keep at most VersionKeep versions (0 = all) and
+	// drop non-current versions older than VersionMaxAge (0 = never)
+	VersionKeep   int
+	VersionMaxAge time.Duration
+
+	// Proxies whose forwarding headers are trusted for client IPs
+	Proxies TrustedProxies
+
+	// Only users with a verified email may share files or create links
+	RequireVerifiedEmail bool
+
+	Audit *Auditor
 }
 
+// storageKey maps a files.filepath value to a backend key. Rows written
+// before the storage backend existed still carry the "uploads/" prefix.
+func storageKey(filePath string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filePath), "uploads/")
+}
+
+// ownRefCount is the ref_count listings report: how many of user $1's own
+// files hold f's content. The global file_hashes.ref_count would tell a
+// user whether anyone else has stored a given file.
+const ownRefCount = `(SELECT COUNT(*) FROM files o WHERE o.file_hash_id = f.file_hash_id AND o.user_id = $1)::int`
+
 // helper: extract user ID from JWT token
 func (h *FileHandler) getUserID(r *http.Request) (int, bool) {
+	// Set by Auth, which also accepts API keys
+	if userID, ok := utils.GetUserID(r.Context()); ok {
+		return userID, true
+	}
+
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		return 0, false
@@ -55,101 +96,64 @@ func (h *FileHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse multipart form (max 10MB here, adjust as needed)
-	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		http.Error(w, "❌ Could not parse form: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	file, handler, err := r.FormFile("file")
+	// ✅ Check / initialize user quota
+	used, quota, err := h.userQuota(r.Context(), userID)
 	if err != nil {
-		http.Error(w, "❌ Could not get file: "+err.Error(), http.StatusBadRequest)
+		http.Error(w, "DB Error ("+err.Error()+")", http.StatusInternalServerError)
 		return
 	}
-	defer file.Close()
-
-	// Read file into memory
-	fileBytes, err := io.ReadAll(file)
-	if err != nil {
-		http.Error(w, "❌ Could not read file: "+err.Error(), http.StatusInternalServerError)
+	limit, errLimit := h.uploadLimit(quota - used)
+	if r.ContentLength > 0 && limit >= 0 && r.ContentLength > limit+64<<10 {
+		// Clearly too big even after subtracting multipart framing
+		writeUploadError(w, errLimit)
 		return
 	}
-	fileSize := int64(len(fileBytes))
-
-	// ✅ Check / initialize user quota
-	var used, quota int64
-	err = h.DB.QueryRow(r.Context(),
-		`SELECT used_bytes, quota_bytes FROM user_storage WHERE user_id=$1`, userID,
-	).Scan(&used, &quota)
 
+	// ✅ Stream the file part to disk, hashing it and enforcing the limit
+	// as bytes arrive instead of buffering the whole upload in memory
+	up, err := h.spoolUpload(r, limit, errLimit)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			// Auto-create record with 100MB quota
-			_, err = h.DB.Exec(r.Context(),
-				`INSERT INTO user_storage (user_id, used_bytes, quota_bytes) VALUES ($1, 0, 104857600)`,
-				userID)
-			if err != nil {
-				http.Error(w, "DB Error (init storage): "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			used, quota = 0, 104857600
-		} else {
-			http.Error(w, "DB Error (check quota): "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	// ✅ Enforce quota
-	if used+fileSize > quota {
-		http.Error(w, "❌ Storage quota exceeded", http.StatusForbidden)
+		writeUploadError(w, err)
 		return
 	}
+	defer os.Remove(up.Path)
 
-	// Compute SHA-256 hash
-	hash := sha256.Sum256(fileBytes)
-	fileHash := hex.EncodeToString(hash[:])
-
-	// Save file physically (if not already present)
-	filePath := "uploads/" + handler.Filename
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		dst, err := CreateFile(filePath)
+	// ✅ A file_id field turns the upload into a new version of that file
+	if id := up.Fields["file_id"]; id != "" {
+		fileID, err := strconv.Atoi(id)
 		if err != nil {
-			http.Error(w, "❌ Could not save file: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		defer dst.Close()
-
-		if _, err := dst.Write(fileBytes); err != nil {
-			http.Error(w, "❌ Could not write file: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "❌ Invalid file_id", http.StatusBadRequest)
 			return
 		}
+		h.uploadVersion(w, r, fileID, up)
+		return
 	}
 
-	// ✅ Insert or update file metadata, store size
-	var fileID int
-	err = h.DB.QueryRow(r.Context(),
-		`INSERT INTO files (user_id, filename, filepath, file_hash, ref_count, uploaded_at, size)
-		 VALUES ($1, $2, $3, $4, 1, NOW(), $5)
-		 ON CONFLICT (file_hash) DO UPDATE 
-		 SET ref_count = files.ref_count + 1
-		 RETURNING id`,
-		userID, handler.Filename, filePath, fileHash, fileSize,
-	).Scan(&fileID)
+	// ✅ Optional target folder
+	folderID, err := h.parseFolderID(r.Context(), userID, up.Fields["folder_id"])
+	if err != nil {
+		http.Error(w, "❌ "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	// ✅ Sniff the real content type and check it against what was declared
+	mimeType, err := h.resolveMIME(up.Head, up.MimeType, up.Filename)
 	if err != nil {
-		http.Error(w, "DB Error (insert file): "+err.Error(), http.StatusInternalServerError)
+		writeUploadError(w, err)
 		return
 	}
 
-	// ✅ Update storage usage
-	_, err = h.DB.Exec(r.Context(),
-		`UPDATE user_storage SET used_bytes = used_bytes + $1 WHERE user_id=$2`,
-		fileSize, userID,
-	)
+	// ✅ Per-user row on top of the shared blob; the blob is stored under
+	// its content hash and the original filename only lives in the files row
+	fileID, err := h.storeFile(r.Context(), userID, folderID, up.Filename, mimeType, up.Hash, up.Size, func(key string) error {
+		return storage.PutFile(r.Context(), h.Store, key, up.Path)
+	})
 	if err != nil {
-		http.Error(w, "DB Error (update storage): "+err.Error(), http.StatusInternalServerError)
+		writeUploadError(w, err)
 		return
 	}
+	h.Audit.Log(r, AuditEvent{Action: AuditUpload, ActorID: &userID, TargetType: "file", TargetID: auditTarget(fileID),
+		Details: map[string]interface{}{"filename": up.Filename, "size": up.Size, "hash": up.Hash}})
 
 	// ✅ Response
 	w.Header().Set("Content-Type", "application/json")
@@ -159,6 +163,7 @@ func (h *FileHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetFiles - list uploaded files for the logged-in user
+// (?folder_id=N limits to one folder, 0 = root)
 func (h *FileHandler) GetFiles(w http.ResponseWriter, r *http.Request) {
 	userID, ok := h.getUserID(r)
 	if !ok {
@@ -166,17 +171,32 @@ func (h *FileHandler) GetFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var folderFilter *int
+	if v := r.URL.Query().Get("folder_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "❌ Invalid folder_id", http.StatusBadRequest)
+			return
+		}
+		folderFilter = &id
+	}
+
 	rows, err := h.DB.Query(r.Context(),
-		`SELECT id,
-		        COALESCE(user_id, 0) AS user_id,
-		        filename,
-		        COALESCE(filepath, '') AS filepath,
-		        file_hash,
-		        COALESCE(ref_count, 0) AS ref_count,
-		        uploaded_at
-		 FROM files
-		 WHERE user_id = $1
-		 ORDER BY uploaded_at DESC`, userID)
+		`SELECT f.id,
+		        COALESCE(f.user_id, 0) AS user_id,
+		        f.folder_id,
+		        f.filename,
+		        COALESCE(f.filepath, '') AS filepath,
+		        f.file_hash,
+		        `+ownRefCount+` AS ref_count,
+		        COALESCE(f.size, 0) AS size,
+		        COALESCE(f.mime_type, '') AS mime_type,
+		        f.uploaded_at,
+		        (SELECT COUNT(*) FROM downloads d WHERE d.file_id = f.id)::int AS download_count
+		 FROM files f
+		 WHERE f.user_id = $1 AND NOT f.is_deleted
+		   AND ($2::int IS NULL OR f.folder_id = $2 OR ($2 = 0 AND f.folder_id IS NULL))
+		 ORDER BY f.uploaded_at DESC`, userID, folderFilter)
 	if err != nil {
 		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -189,11 +209,15 @@ func (h *FileHandler) GetFiles(w http.ResponseWriter, r *http.Request) {
 		if err := rows.Scan(
 			&f.ID,
 			&f.UserID,
+			&f.FolderID,
 			&f.Filename,
 			&f.Filepath,
 			&f.FileHash,
 			&f.RefCount,
+			&f.Size,
+			&f.MimeType,
 			&f.UploadedAt,
+			&f.DownloadCount,
 		); err != nil {
 			http.Error(w, "Scan Error: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -213,16 +237,24 @@ func (h *FileHandler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	vars := mux.Vars(r)
-	fileID := vars["id"]
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid file id", http.StatusBadRequest)
+		return
+	}
+	// Owner, or any share permission
+	if _, _, ok := h.requirePermission(w, r, fileID, userID, PermRead); !ok {
+		return
+	}
 
-	var ownerID int
-	var filePath, fileName string
-	err := h.DB.QueryRow(r.Context(),
-		`SELECT user_id, filename, filepath FROM files WHERE id=$1`, fileID).
-		Scan(&ownerID, &fileName, &filePath)
+	var m blobMeta
+	err = h.DB.QueryRow(r.Context(),
+		`SELECT filename, COALESCE(filepath, ''), file_hash, COALESCE(mime_type, ''),
+		        COALESCE(size, 0), COALESCE(updated_at, uploaded_at)
+		 FROM files WHERE id=$1 AND NOT is_deleted`, fileID).
+		Scan(&m.Filename, &m.Key, &m.Hash, &m.MimeType, &m.Size, &m.ModTime)
 
-	if err == sql.ErrNoRows {
+	if err == pgx.ErrNoRows {
 		http.Error(w, "❌ File not found", http.StatusNotFound)
 		return
 	} else if err != nil {
@@ -230,59 +262,156 @@ func (h *FileHandler) DownloadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check ownership or shared access
-	if ownerID != userID {
-		var count int
-		err := h.DB.QueryRow(r.Context(),
-			`SELECT COUNT(*) FROM shares WHERE file_id=$1 AND target_user=$2`,
-			fileID, userID).Scan(&count)
-		if err != nil || count == 0 {
-			http.Error(w, "❌ Forbidden", http.StatusForbidden)
-			return
-		}
-	}
-
-	w.Header().Set("Content-Disposition", "attachment; filename="+fileName)
-	http.ServeFile(w, r, filepath.Clean(filePath))
+	m.Key = storageKey(m.Key)
+	h.serveDownload(w, r, fileID, m, downloadSource{UserID: &userID})
 }
 
-// ShareFile - share a file with another user
+// ShareFile - share a file with other users, named by username or email
+// in "recipients" (target_user still takes a numeric id), and with groups
+// the caller belongs to, by id in "groups". The owner can
+// grant any permission; users holding reshare can pass on up to their own.
+// Unknown recipients fail the whole request with 404, unless "invite" is
+// set and they're email addresses: those become pending invites that turn
+// into shares when the address registers.
 func (h *FileHandler) ShareFile(w http.ResponseWriter, r *http.Request) {
 	userID, ok := h.getUserID(r)
 	if !ok {
 		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if !h.requireVerified(w, r, userID) {
+		return
+	}
 
 	var req struct {
-		FileID     int    `json:"file_id"`
-		TargetUser int    `json:"target_user"`
-		ShareType  string `json:"share_type"`
+		FileID     int      `json:"file_id"`
+		TargetUser int      `json:"target_user"`
+		Recipients []string `json:"recipients"`
+		Groups     []int    `json:"groups"`
+		ShareType  string   `json:"share_type"`
+		Invite     bool     `json:"invite"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
+	if req.TargetUser == 0 && len(req.Recipients) == 0 && len(req.Groups) == 0 {
+		http.Error(w, "❌ No recipients given", http.StatusBadRequest)
+		return
+	}
 
 	// Default share_type if empty
 	if req.ShareType == "" {
-		req.ShareType = "read"
+		req.ShareType = PermRead
+	}
+	if !validPermission(req.ShareType) {
+		http.Error(w, invalidPermission(), http.StatusBadRequest)
+		return
 	}
 
-	// Insert into shares table
-	_, err := h.DB.Exec(r.Context(),
-		`INSERT INTO shares (file_id, owner_id, target_user, share_type, shared_at)
-         VALUES ($1, $2, $3, $4, NOW())`,
-		req.FileID, userID, req.TargetUser, req.ShareType,
-	)
+	ownerID, perm, ok := h.requirePermission(w, r, req.FileID, userID, PermReshare)
+	if !ok {
+		return
+	}
+	if !allows(perm, req.ShareType) {
+		http.Error(w, "❌ Cannot grant more than your own permission", http.StatusForbidden)
+		return
+	}
+
+	targets, invites, err := h.resolveRecipients(r.Context(), req.TargetUser, req.Recipients, req.Invite)
+	var unknown unknownRecipientsError
+	if errors.As(err, &unknown) {
+		http.Error(w, "❌ "+unknown.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Only groups you're in, so ids of other teams' groups can't be probed
+	if len(req.Groups) > 0 {
+		var n int
+		err := h.DB.QueryRow(r.Context(),
+			`SELECT COUNT(DISTINCT group_id) FROM group_members WHERE user_id=$1 AND group_id = ANY($2)`,
+			userID, req.Groups).Scan(&n)
+		if err != nil {
+			http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n != len(uniqueInts(req.Groups)) {
+			http.Error(w, "❌ Group not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	type shared struct {
+		Recipient string `json:"recipient"`
+		UserID    int    `json:"user_id"`
+	}
+	result := struct {
+		Message string   `json:"message"`
+		Shared  []shared `json:"shared"`
+		Groups  []int    `json:"groups"`
+		Invited []string `json:"invited"`
+		Skipped []string `json:"skipped"`
+	}{Shared: []shared{}, Groups: []int{}, Invited: []string{}, Skipped: []string{}}
+
+	// Sharing again with the same user updates the permission
+	err = pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		for _, t := range targets {
+			if t.userID == userID || t.userID == ownerID {
+				// They already have access
+				result.Skipped = append(result.Skipped, t.recipient)
+				continue
+			}
+			_, err := tx.Exec(r.Context(),
+				`INSERT INTO shares (file_id, shared_by, target_user, share_type, shared_at)
+				 VALUES ($1, $2, $3, $4, NOW())
+				 ON CONFLICT (file_id, shared_by, target_user) DO UPDATE SET share_type = EXCLUDED.share_type`,
+				req.FileID, userID, t.userID, req.ShareType,
+			)
+			if err != nil {
+				return err
+			}
+			result.Shared = append(result.Shared, shared{t.recipient, t.userID})
+		}
+		for _, groupID := range uniqueInts(req.Groups) {
+			_, err := tx.Exec(r.Context(),
+				`INSERT INTO shares (file_id, shared_by, target_group, share_type, shared_at)
+				 VALUES ($1, $2, $3, $4, NOW())
+				 ON CONFLICT (file_id, shared_by, target_group) WHERE target_group IS NOT NULL
+				 DO UPDATE SET share_type = EXCLUDED.share_type`,
+				req.FileID, userID, groupID, req.ShareType)
+			if err != nil {
+				return err
+			}
+			result.Groups = append(result.Groups, groupID)
+		}
+		for _, email := range invites {
+			_, err := tx.Exec(r.Context(),
+				`INSERT INTO share_invites (file_id, invited_by, email, share_type)
+				 VALUES ($1, $2, $3, $4)
+				 ON CONFLICT (file_id, invited_by, email) DO UPDATE SET share_type = EXCLUDED.share_type`,
+				req.FileID, userID, email, req.ShareType)
+			if err != nil {
+				return err
+			}
+			result.Invited = append(result.Invited, email)
+		}
+		return nil
+	})
 	if err != nil {
 		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	h.Audit.Log(r, AuditEvent{Action: AuditShareGrant, ActorID: &userID, TargetType: "file", TargetID: auditTarget(req.FileID),
+		Details: map[string]interface{}{"share_type": req.ShareType, "users": result.Shared, "groups": result.Groups, "invited": result.Invited}})
+
+	result.Message = "✅ File shared successfully"
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"message":"✅ File shared successfully"}`))
+	json.NewEncoder(w).Encode(result)
 }
 
 // GetSharedFiles - list files shared *with* the logged-in user
@@ -294,12 +423,15 @@ func (h *FileHandler) GetSharedFiles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rows, err := h.DB.Query(r.Context(),
-		`SELECT f.id, f.user_id, f.filename, f.filepath, f.file_hash, f.ref_count, f.uploaded_at,
-                s.share_type, s.shared_by
+		`SELECT f.id, f.user_id, f.filename, COALESCE(f.filepath, ''), f.file_hash,
+                `+ownRefCount+`, COALESCE(f.size, 0), COALESCE(f.mime_type, ''), f.uploaded_at,
+                s.share_type, s.shared_by, s.target_group, g.name
          FROM files f
          JOIN shares s ON f.id = s.file_id
-         WHERE s.target_user=$1
-         ORDER BY s.shared_at DESC`, userID)
+         LEFT JOIN groups g ON g.id = s.target_group
+         WHERE (s.target_user=$1 OR s.target_group IN (SELECT group_id FROM group_members WHERE user_id=$1))
+           AND f.user_id <> $1 AND NOT f.is_deleted AND s.share_type = ANY($2)
+         ORDER BY s.shared_at DESC`, userID, sharePermissions)
 
 	if err != nil {
 		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
@@ -309,21 +441,61 @@ func (h *FileHandler) GetSharedFiles(w http.ResponseWriter, r *http.Request) {
 
 	type SharedFile struct {
 		models.File
-		SharedBy  int    `json:"shared_by"`
-		ShareType string `json:"share_type"`
+		SharedBy    int      `json:"shared_by"`
+		ShareType   string   `json:"share_type"`
+		Permissions []string `json:"permissions"`
+		GroupID     *int     `json:"group_id"`
+		GroupName   *string  `json:"group_name"`
 	}
 
-	var files []SharedFile
+	var shared []SharedFile
 	for rows.Next() {
 		var sf SharedFile
 		if err := rows.Scan(
 			&sf.ID, &sf.UserID, &sf.Filename, &sf.Filepath,
-			&sf.FileHash, &sf.RefCount, &sf.UploadedAt,
+			&sf.FileHash, &sf.RefCount, &sf.Size, &sf.MimeType, &sf.UploadedAt,
 			&sf.ShareType, &sf.SharedBy, // ✅ fixed mapping
+			&sf.GroupID, &sf.GroupName,
 		); err != nil {
 			http.Error(w, "Scan Error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		shared = append(shared, sf)
+	}
+	rows.Close()
+
+	// A file can reach the user through a direct share, several groups or
+	// several sharers. List it once, at its newest share, with the
+	// strongest grant (what filePermission will enforce). Reshares only
+	// count while their grantor still holds reshare.
+	var files []SharedFile
+	seen := map[int]int{}              // file id → index in files
+	grantors := map[int]map[int]bool{} // file id → valid grantors
+	for _, sf := range shared {
+		if sf.SharedBy != sf.UserID {
+			valid, ok := grantors[sf.ID]
+			if !ok {
+				grants, err := h.fileGrants(r.Context(), sf.ID)
+				if err != nil {
+					http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				valid = validGrantors(sf.UserID, grants)
+				grantors[sf.ID] = valid
+			}
+			if !valid[sf.SharedBy] {
+				continue
+			}
+		}
+		sf.Permissions = grantedPermissions(sf.ShareType)
+		if i, ok := seen[sf.ID]; ok {
+			if prev := &files[i]; permLevels[sf.ShareType] > permLevels[prev.ShareType] {
+				prev.ShareType, prev.Permissions = sf.ShareType, sf.Permissions
+				prev.SharedBy, prev.GroupID, prev.GroupName = sf.SharedBy, sf.GroupID, sf.GroupName
+			}
+			continue
+		}
+		seen[sf.ID] = len(files)
 		files = append(files, sf)
 	}
 
@@ -331,54 +503,41 @@ func (h *FileHandler) GetSharedFiles(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(files)
 }
 
-// CreateFile ensures the uploads directory exists and creates the file
-func CreateFile(path string) (*os.File, error) {
-	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
-		return nil, err
-	}
-	return os.Create(path)
-}
-
 // GET /storage → check quota usage
 // GET /storage → check quota usage
 func (h *FileHandler) GetStorage(w http.ResponseWriter, r *http.Request) {
-	userID, ok := GetUserIDFromToken(r)
+	userID, ok := h.getUserID(r)
 	if !ok {
 		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	var used, quota int64
-	err := h.DB.QueryRow(r.Context(),
-		`SELECT used_bytes, quota_bytes FROM user_storage WHERE user_id=$1`, userID,
-	).Scan(&used, &quota)
+	used, quota, err := h.userQuota(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "DB Error ("+err.Error()+")", http.StatusInternalServerError)
+		return
+	}
 
+	var trashBytes int64
+	err = h.DB.QueryRow(r.Context(),
+		`SELECT COALESCE(SUM(COALESCE(total_size, size)), 0) FROM files WHERE user_id=$1 AND is_deleted`, userID,
+	).Scan(&trashBytes)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			// ✅ Auto-create record with 100MB quota
-			_, err = h.DB.Exec(r.Context(),
-				`INSERT INTO user_storage (user_id, used_bytes, quota_bytes) VALUES ($1, 0, 104857600)`,
-				userID)
-			if err != nil {
-				http.Error(w, "DB Error (init storage): "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			used, quota = 0, 104857600
-		} else {
-			http.Error(w, "DB Error (check quota): "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"used_bytes":   used,
-		"quota_bytes":  quota,
-		"percent_used": float64(used) / float64(quota) * 100,
+		"used_bytes":                used,
+		"quota_bytes":               quota,
+		"percent_used":              float64(used) / float64(quota) * 100,
+		"trash_bytes":               trashBytes,
+		"trash_counts_toward_quota": h.TrashCountsQuota,
 	})
 }
 
-// DeleteFile - delete a file owned by the user
-// DeleteFile - delete a file owned by the user
+// DeleteFile - move a file owned by the user to the trash
 func (h *FileHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 	userID, ok := h.getUserID(r)
 	if !ok {
@@ -386,19 +545,24 @@ func (h *FileHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	vars := mux.Vars(r)
-	fileID := vars["id"]
-
-	var ownerID int
-	var filePath string
-	var refCount int
-	var fileSize int64
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid file id", http.StatusBadRequest)
+		return
+	}
 
-	// ✅ Get file info including size
-	err := h.DB.QueryRow(r.Context(),
-		`SELECT user_id, filepath, ref_count, size FROM files WHERE id=$1`, fileID).
-		Scan(&ownerID, &filePath, &refCount, &fileSize)
+	tx, err := h.DB.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(r.Context())
 
+	var fileSize int64
+	err = tx.QueryRow(r.Context(),
+		`UPDATE files SET is_deleted = true, deleted_at = NOW()
+		 WHERE id=$1 AND user_id=$2 AND NOT is_deleted
+		 RETURNING COALESCE(total_size, size, 0)`, fileID, userID).Scan(&fileSize)
 	if err == pgx.ErrNoRows {
 		http.Error(w, "❌ File not found", http.StatusNotFound)
 		return
@@ -407,45 +571,92 @@ func (h *FileHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ensure user owns file
-	if ownerID != userID {
-		http.Error(w, "❌ Forbidden", http.StatusForbidden)
-		return
-	}
-
-	if refCount > 1 {
-		// Just decrement ref_count
-		_, err = h.DB.Exec(r.Context(),
-			`UPDATE files SET ref_count = ref_count - 1 WHERE id=$1`, fileID)
+	// ✅ Trashed bytes only stop counting if configured so
+	if !h.chargesQuota(true) {
+		_, err = tx.Exec(r.Context(),
+			`UPDATE user_storage SET used_bytes = GREATEST(used_bytes - $1, 0) WHERE user_id=$2`,
+			fileSize, userID,
+		)
 		if err != nil {
-			http.Error(w, "DB Error (decrement ref_count): "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-	} else {
-		// Remove DB row and physical file
-		_, err = h.DB.Exec(r.Context(),
-			`DELETE FROM files WHERE id=$1`, fileID)
-		if err != nil {
-			http.Error(w, "DB Error (delete file): "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "DB Error (update storage): "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "DB Error (commit): "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
-			http.Error(w, "❌ Could not delete file: "+err.Error(), http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/json")
+	h.Audit.Log(r, AuditEvent{Action: AuditTrash, ActorID: &userID, TargetType: "file", TargetID: auditTarget(fileID)})
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ File moved to trash"})
+}
+
+// RenameFile - rename and/or move an entry (PATCH /files/{id}). Renaming
+// needs write permission; only the owner can move it between their folders.
+// folder_id 0 moves the file to the root.
+func (h *FileHandler) RenameFile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Filename *string `json:"filename"`
+		FolderID *int    `json:"folder_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Filename == nil && req.FolderID == nil) {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+	if req.Filename != nil && strings.TrimSpace(*req.Filename) == "" {
+		http.Error(w, "❌ Filename cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid file id", http.StatusBadRequest)
+		return
+	}
+	ownerID, perm, ok := h.requirePermission(w, r, fileID, userID, PermWrite)
+	if !ok {
+		return
+	}
+	if req.FolderID != nil && perm != permOwner {
+		http.Error(w, "❌ Only the owner can move a file", http.StatusForbidden)
+		return
+	}
+
+	var newName *string
+	if req.Filename != nil {
+		name := strings.TrimSpace(*req.Filename)
+		newName = &name
+	}
+	var folderID *int
+	if req.FolderID != nil {
+		if folderID, err = h.parseFolderID(r.Context(), ownerID, strconv.Itoa(*req.FolderID)); err != nil {
+			http.Error(w, "❌ "+err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
 
-	// ✅ Subtract file size from quota
-	_, err = h.DB.Exec(r.Context(),
-		`UPDATE user_storage SET used_bytes = GREATEST(used_bytes - $1, 0) WHERE user_id=$2`,
-		fileSize, userID,
-	)
+	tag, err := h.DB.Exec(r.Context(),
+		`UPDATE files
+		 SET filename = COALESCE($1, filename),
+		     folder_id = CASE WHEN $2 THEN $3 ELSE folder_id END
+		 WHERE id=$4 AND NOT is_deleted`,
+		newName, req.FolderID != nil, folderID, fileID)
 	if err != nil {
-		http.Error(w, "DB Error (update storage): "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "❌ File not found", http.StatusNotFound)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "✅ File deleted successfully"})
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ File updated successfully"})
 }
@@ -0,0 +1,378 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/storage"
+	"github.com/jackc/pgx/v5"
+)
+
+var errFileNotFound = errors.New("file not found")
+
+// Blob references: every file_versions row holds one reference on its
+// file_hashes row. The files row only points at the current version's blob
+// (rows from before versioning, which have no versions, hold the reference
+// themselves). files.total_size is what the owner is charged for: the size
+// of every distinct blob across the file's versions.
+
+// lockBlob takes a lock on fileHash for the rest of tx. Uploads hold it
+// from retainBlob until they commit, and dropBlobs holds it while deleting,
+// so an upload can never race a delete of the last reference.
+func lockBlob(ctx context.Context, tx pgx.Tx, fileHash string) error {
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, fileHash); err != nil {
+		return fmt.Errorf("lock blob: %w", err)
+	}
+	return nil
+}
+
+// retainBlob upserts the file_hashes row for fileHash and takes a reference
+// on it, holding the blob's lock (see lockBlob) for the rest of tx.
+func retainBlob(ctx context.Context, tx pgx.Tx, fileHash string, size int64) (int, error) {
+	if err := lockBlob(ctx, tx, fileHash); err != nil {
+		return 0, err
+	}
+	var hashID int
+	err := tx.QueryRow(ctx,
+		`INSERT INTO file_hashes (hash, size, ref_count) VALUES ($1, $2, 1)
+		 ON CONFLICT (hash) DO UPDATE SET ref_count = file_hashes.ref_count + 1
+		 RETURNING id`,
+		fileHash, size,
+	).Scan(&hashID)
+	if err != nil {
+		return 0, fmt.Errorf("upsert blob: %w", err)
+	}
+	return hashID, nil
+}
+
+// ensureBlob calls put if the blob for fileHash is missing from the backend.
+// Callers do this last in their transaction so a failed insert or quota
+// check never leaves an unreferenced object behind.
+func (h *FileHandler) ensureBlob(ctx context.Context, fileHash string, put func(key string) error) error {
+	key := storage.HashKey(fileHash)
+	if _, err := h.Store.Stat(ctx, key); errors.Is(err, storage.ErrNotFound) {
+		if err := put(key); err != nil {
+			return fmt.Errorf("write blob: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("check blob: %w", err)
+	}
+	return nil
+}
+
+// chargeQuota adds size bytes to the user's usage, failing with
+// errQuotaExceeded if that would go over quota. The check is atomic so
+// parallel uploads can't both squeeze in.
+func chargeQuota(ctx context.Context, tx pgx.Tx, userID int, size int64) error {
+	tag, err := tx.Exec(ctx,
+		`UPDATE user_storage SET used_bytes = used_bytes + $1
+		 WHERE user_id=$2 AND used_bytes + $1 <= quota_bytes`,
+		size, userID)
+	if err != nil {
+		return fmt.Errorf("update storage: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// refundQuota gives size bytes back to the user
+func refundQuota(ctx context.Context, tx pgx.Tx, userID int, size int64) error {
+	_, err := tx.Exec(ctx,
+		`UPDATE user_storage SET used_bytes = GREATEST(used_bytes - $1, 0) WHERE user_id=$2`,
+		size, userID)
+	if err != nil {
+		return fmt.Errorf("update storage: %w", err)
+	}
+	return nil
+}
+
+// storeFile creates a per-user files row (version 1) pointing at the
+// deduplicated blob for fileHash and charges size bytes to the user's
+// quota. put is only called if the blob is missing from the backend.
+func (h *FileHandler) storeFile(ctx context.Context, userID int, folderID *int, filename, mimeType, fileHash string, size int64, put func(key string) error) (int, error) {
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	hashID, err := retainBlob(ctx, tx, fileHash, size)
+	if err != nil {
+		return 0, err
+	}
+
+	var fileID int
+	err = tx.QueryRow(ctx,
+		`INSERT INTO files (user_id, folder_id, file_hash_id, filename, mime_type, filepath, file_hash,
+		                    uploaded_at, size, total_size, current_version)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), $8, $8, 1)
+		 RETURNING id`,
+		userID, folderID, hashID, filename, mimeType, storage.HashKey(fileHash), fileHash, size,
+	).Scan(&fileID)
+	if err != nil {
+		return 0, fmt.Errorf("insert file: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO file_versions (file_id, version, file_hash_id, file_hash, size, mime_type, created_by)
+		 VALUES ($1, 1, $2, $3, $4, $5, $6)`,
+		fileID, hashID, fileHash, size, mimeType, userID)
+	if err != nil {
+		return 0, fmt.Errorf("insert version: %w", err)
+	}
+
+	if err := chargeQuota(ctx, tx, userID, size); err != nil {
+		return 0, err
+	}
+	if err := h.ensureBlob(ctx, fileHash, put); err != nil {
+		return 0, err
+	}
+	return fileID, tx.Commit(ctx)
+}
+
+// addVersion makes new content the current version of an existing file.
+// Uploading the current content again is a no-op; content identical to an
+// older version shares its blob and isn't charged twice. The file owner
+// pays for the new bytes, whoever the author is. Returns the version number.
+func (h *FileHandler) addVersion(ctx context.Context, fileID, authorID int, mimeType, fileHash string, size int64, put func(key string) error) (int, error) {
+	tx, err := h.DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var ownerID, current int
+	var currentHash string
+	var deleted bool
+	err = tx.QueryRow(ctx,
+		`SELECT user_id, COALESCE(current_version, 0), file_hash, is_deleted
+		 FROM files WHERE id=$1 FOR UPDATE`, fileID,
+	).Scan(&ownerID, &current, &currentHash, &deleted)
+	if err == pgx.ErrNoRows || (err == nil && deleted) {
+		return 0, errFileNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	if currentHash == fileHash && current > 0 {
+		return current, nil
+	}
+	if current == 0 {
+		// File predates versioning: its own reference becomes version 1
+		_, err = tx.Exec(ctx,
+			`INSERT INTO file_versions (file_id, version, file_hash_id, file_hash, size, mime_type, created_by, created_at)
+			 SELECT id, 1, file_hash_id, file_hash, COALESCE(size, 0), mime_type, user_id, uploaded_at
+			 FROM files WHERE id=$1`, fileID)
+		if err != nil {
+			return 0, fmt.Errorf("adopt version: %w", err)
+		}
+	}
+
+	hashID, err := retainBlob(ctx, tx, fileHash, size)
+	if err != nil {
+		return 0, err
+	}
+
+	var dup bool
+	var next int
+	err = tx.QueryRow(ctx,
+		`SELECT COALESCE(bool_or(file_hash_id = $2), false), COALESCE(MAX(version), 0) + 1
+		 FROM file_versions WHERE file_id=$1`, fileID, hashID,
+	).Scan(&dup, &next)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO file_versions (file_id, version, file_hash_id, file_hash, size, mime_type, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		fileID, next, hashID, fileHash, size, mimeType, authorID)
+	if err != nil {
+		return 0, fmt.Errorf("insert version: %w", err)
+	}
+
+	charge := size
+	if dup {
+		charge = 0
+	}
+	_, err = tx.Exec(ctx,
+		`UPDATE files SET file_hash_id=$2, file_hash=$3, filepath=$4, size=$5, mime_type=$6,
+		                  current_version=$7, total_size = COALESCE(total_size, size, 0) + $8, updated_at = NOW()
+		 WHERE id=$1`,
+		fileID, hashID, fileHash, storage.HashKey(fileHash), size, mimeType, next, charge)
+	if err != nil {
+		return 0, fmt.Errorf("update file: %w", err)
+	}
+	if err := chargeQuota(ctx, tx, ownerID, charge); err != nil {
+		return 0, err
+	}
+
+	stale, err := h.pruneVersions(ctx, tx, fileID)
+	if err != nil {
+		return 0, err
+	}
+	if err := h.ensureBlob(ctx, fileHash, put); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	h.dropBlobs(ctx, stale)
+	return next, nil
+}
+
+// userQuota returns the user's used and total bytes, creating the
+// user_storage row with the default 100MB quota if it is missing.
+func (h *FileHandler) userQuota(ctx context.Context, userID int) (used, quota int64, err error) {
+	err = h.DB.QueryRow(ctx,
+		`SELECT used_bytes, quota_bytes FROM user_storage WHERE user_id=$1`, userID,
+	).Scan(&used, &quota)
+	if err == pgx.ErrNoRows {
+		// Auto-create record with 100MB quota
+		_, err = h.DB.Exec(ctx,
+			`INSERT INTO user_storage (user_id, used_bytes, quota_bytes) VALUES ($1, 0, 104857600)
+			 ON CONFLICT (user_id) DO NOTHING`,
+			userID)
+		if err != nil {
+			return 0, 0, fmt.Errorf("init storage: %w", err)
+		}
+		return 0, 104857600, nil
+	} else if err != nil {
+		return 0, 0, fmt.Errorf("check quota: %w", err)
+	}
+	return used, quota, nil
+}
+
+// purgeFile permanently deletes a files row and all its versions inside
+// tx: every blob reference is released and any quota the file still holds
+// is returned. Blobs left without references are returned for dropBlobs.
+// Callers check ownership.
+func (h *FileHandler) purgeFile(ctx context.Context, tx pgx.Tx, fileID int) ([]staleBlob, error) {
+	var ownerID int
+	var hashID *int
+	var filePath string
+	var totalSize int64
+	var deleted bool
+	err := tx.QueryRow(ctx,
+		`SELECT user_id, file_hash_id, COALESCE(filepath, ''), COALESCE(total_size, size, 0), is_deleted
+		 FROM files WHERE id=$1 FOR UPDATE`, fileID,
+	).Scan(&ownerID, &hashID, &filePath, &totalSize, &deleted)
+	if err == pgx.ErrNoRows {
+		return nil, errFileNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `SELECT file_hash_id FROM file_versions WHERE file_id=$1`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	versionHashes, err := pgx.CollectRows(rows, pgx.RowTo[*int])
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove the entry (versions cascade); blobs go with their last reference
+	if _, err := tx.Exec(ctx, `DELETE FROM files WHERE id=$1`, fileID); err != nil {
+		return nil, fmt.Errorf("delete file: %w", err)
+	}
+	var stale []staleBlob
+	if len(versionHashes) == 0 {
+		if stale, err = h.releaseFile(ctx, tx, hashID, filePath); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range versionHashes {
+		if id == nil {
+			continue
+		}
+		blobs, err := h.releaseFile(ctx, tx, id, "")
+		if err != nil {
+			return nil, err
+		}
+		stale = append(stale, blobs...)
+	}
+
+	if h.chargesQuota(deleted) {
+		if err := refundQuota(ctx, tx, ownerID, totalSize); err != nil {
+			return nil, err
+		}
+	}
+	return stale, nil
+}
+
+// chargesQuota reports whether a file in the given trash state currently
+// counts towards its owner's used_bytes (see TrashCountsQuota)
+func (h *FileHandler) chargesQuota(deleted bool) bool {
+	return !deleted || h.TrashCountsQuota
+}
+
+// staleBlob is a blob whose last reference went away inside a
+// transaction. It stays in the backend until that transaction commits;
+// see dropBlobs.
+type staleBlob struct {
+	Key  string
+	Hash string // empty for rows from before file_hashes
+}
+
+// releaseFile drops one reference to a blob inside tx. Once nothing points
+// at it any more, the file_hashes row goes and the blob is returned for the
+// caller to pass to dropBlobs after commit.
+func (h *FileHandler) releaseFile(ctx context.Context, tx pgx.Tx, hashID *int, filePath string) ([]staleBlob, error) {
+	if hashID == nil {
+		// Row from before file_hashes was in use: the path is the only reference
+		return []staleBlob{{Key: storageKey(filePath)}}, nil
+	}
+
+	var refs int
+	var hash string
+	err := tx.QueryRow(ctx,
+		`UPDATE file_hashes SET ref_count = ref_count - 1 WHERE id=$1 RETURNING ref_count, hash`,
+		*hashID,
+	).Scan(&refs, &hash)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("decrement ref_count: %w", err)
+	}
+	if refs > 0 {
+		return nil, nil
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM file_hashes WHERE id=$1`, *hashID); err != nil {
+		return nil, fmt.Errorf("delete blob row: %w", err)
+	}
+	return []staleBlob{{Key: storage.HashKey(hash), Hash: hash}}, nil
+}
+
+// dropBlobs deletes blobs released by a committed transaction. Each one is
+// re-checked under the hash's lock (see lockBlob), since the same content
+// may have been uploaded again in the meantime. Failures are only logged:
+// an orphaned blob wastes space, but deleting one still in use loses data.
+func (h *FileHandler) dropBlobs(ctx context.Context, blobs []staleBlob) {
+	ctx = context.WithoutCancel(ctx)
+	for _, b := range blobs {
+		err := pgx.BeginFunc(ctx, h.DB, func(tx pgx.Tx) error {
+			if b.Hash != "" {
+				if err := lockBlob(ctx, tx, b.Hash); err != nil {
+					return err
+				}
+				var used bool
+				if err := tx.QueryRow(ctx,
+					`SELECT EXISTS (SELECT 1 FROM file_hashes WHERE hash=$1)`, b.Hash).Scan(&used); err != nil {
+					return err
+				}
+				if used {
+					return nil
+				}
+			}
+			return h.Store.Delete(ctx, b.Key)
+		})
+		if err != nil {
+			log.Printf("⚠️ deleting blob %s failed, leaving it orphaned: %v", b.Key, err)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	errFolderNotFound = errors.New("folder not found")
+	errFolderCycle    = errors.New("cannot move a folder into itself or one of its subfolders")
+	errFolderExists   = errors.New("a folder with that name already exists here")
+)
+
+// folderNameMax is the length of folders.name, in characters
+const folderNameMax = 255
+
+// folderName trims name and checks it fits in folders.name
+func folderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("name cannot be empty")
+	}
+	if utf8.RuneCountInString(name) > folderNameMax {
+		return "", fmt.Errorf("name must be at most %d characters", folderNameMax)
+	}
+	return name, nil
+}
+
+// parseFolderID turns a folder_id form/query value into a folder owned by
+// userID. "" and "0" mean the root (nil).
+func (h *FileHandler) parseFolderID(ctx context.Context, userID int, v string) (*int, error) {
+	if v == "" || v == "0" {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, errors.New("invalid folder_id")
+	}
+	var ok bool
+	err = h.DB.QueryRow(ctx,
+		`SELECT true FROM folders WHERE id=$1 AND user_id=$2`, id, userID).Scan(&ok)
+	if err == pgx.ErrNoRows {
+		return nil, errFolderNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// folderLockClass namespaces the per-user folder tree advisory locks
+const folderLockClass = 0x666f6c64 // "fold"
+
+// lockFolders serializes structural changes to userID's folder tree for
+// the rest of tx. Locking only the moved folder isn't enough: moving A
+// under B and B under A at the same time would both pass the cycle check.
+func lockFolders(ctx context.Context, tx pgx.Tx, userID int) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, folderLockClass, userID)
+	return err
+}
+
+// subtree returns the ids of folderID and all folders below it. UNION
+// rather than UNION ALL so a cycle in the data can't recurse forever.
+func subtree(ctx context.Context, q pgx.Tx, folderID int) ([]int, error) {
+	rows, err := q.Query(ctx,
+		`WITH RECURSIVE sub AS (
+		     SELECT id FROM folders WHERE id=$1
+		     UNION
+		     SELECT f.id FROM folders f JOIN sub ON f.parent_id = sub.id
+		 )
+		 SELECT id FROM sub`, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int])
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint error
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// POST /folders → create a folder ({"name", "parent_id"})
+func (h *FileHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name     string `json:"name"`
+		ParentID int    `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+	name, err := folderName(req.Name)
+	if err != nil {
+		http.Error(w, "❌ "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parentID, err := h.parseFolderID(r.Context(), userID, strconv.Itoa(req.ParentID))
+	if err != nil {
+		http.Error(w, "❌ "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f := models.Folder{UserID: userID, ParentID: parentID, Name: name}
+	err = h.DB.QueryRow(r.Context(),
+		`INSERT INTO folders (user_id, parent_id, name) VALUES ($1, $2, $3)
+		 RETURNING id, created_at`,
+		userID, parentID, f.Name,
+	).Scan(&f.ID, &f.CreatedAt)
+	if isUniqueViolation(err) {
+		http.Error(w, "❌ "+errFolderExists.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(f)
+}
+
+// GET /folders and GET /folders/{id} → list a folder's subfolders and files
+func (h *FileHandler) GetFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	listing := models.FolderListing{Folders: []models.Folder{}, Files: []models.File{}}
+	if v := mux.Vars(r)["id"]; v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "❌ Invalid folder id", http.StatusBadRequest)
+			return
+		}
+		var f models.Folder
+		err = h.DB.QueryRow(r.Context(),
+			`SELECT id, user_id, parent_id, name, created_at FROM folders WHERE id=$1 AND user_id=$2`,
+			id, userID,
+		).Scan(&f.ID, &f.UserID, &f.ParentID, &f.Name, &f.CreatedAt)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "❌ Folder not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		listing.Folder = &f
+	}
+
+	var parent *int
+	if listing.Folder != nil {
+		parent = &listing.Folder.ID
+	}
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT id, user_id, parent_id, name, created_at
+		 FROM folders
+		 WHERE user_id=$1 AND parent_id IS NOT DISTINCT FROM $2
+		 ORDER BY name`, userID, parent)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f models.Folder
+		if err := rows.Scan(&f.ID, &f.UserID, &f.ParentID, &f.Name, &f.CreatedAt); err != nil {
+			http.Error(w, "Scan Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		listing.Folders = append(listing.Folders, f)
+	}
+	rows.Close()
+
+	rows, err = h.DB.Query(r.Context(),
+		`SELECT f.id, f.user_id, f.folder_id, f.filename, COALESCE(f.filepath, ''), f.file_hash,
+		        `+ownRefCount+`, COALESCE(f.size, 0), COALESCE(f.mime_type, ''), f.uploaded_at,
+		        (SELECT COUNT(*) FROM downloads d WHERE d.file_id = f.id)::int
+		 FROM files f
+		 WHERE f.user_id=$1 AND NOT f.is_deleted AND f.folder_id IS NOT DISTINCT FROM $2
+		 ORDER BY f.filename`, userID, parent)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f models.File
+		if err := rows.Scan(&f.ID, &f.UserID, &f.FolderID, &f.Filename, &f.Filepath, &f.FileHash,
+			&f.RefCount, &f.Size, &f.MimeType, &f.UploadedAt, &f.DownloadCount); err != nil {
+			http.Error(w, "Scan Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		listing.Files = append(listing.Files, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listing)
+}
+
+// PATCH /folders/{id} → rename ({"name"}) and/or move ({"parent_id"}, 0 = root).
+// Moving a folder carries its whole subtree along.
+func (h *FileHandler) UpdateFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	folderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid folder id", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Name     *string `json:"name"`
+		ParentID *int    `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Name == nil && req.ParentID == nil) {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+	if req.Name != nil {
+		name, err := folderName(*req.Name)
+		if err != nil {
+			http.Error(w, "❌ "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Name = &name
+	}
+
+	tx, err := h.DB.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	if err := lockFolders(r.Context(), tx, userID); err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var exists bool
+	err = tx.QueryRow(r.Context(),
+		`SELECT true FROM folders WHERE id=$1 AND user_id=$2 FOR UPDATE`, folderID, userID).Scan(&exists)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ Folder not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var parentID *int
+	if req.ParentID != nil {
+		if parentID, err = h.parseFolderID(r.Context(), userID, strconv.Itoa(*req.ParentID)); err != nil {
+			http.Error(w, "❌ "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if parentID != nil {
+			ids, err := subtree(r.Context(), tx, folderID)
+			if err != nil {
+				http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for _, id := range ids {
+				if id == *parentID {
+					http.Error(w, "❌ "+errFolderCycle.Error(), http.StatusConflict)
+					return
+				}
+			}
+		}
+	}
+
+	_, err = tx.Exec(r.Context(),
+		`UPDATE folders
+		 SET name = COALESCE($1, name),
+		     parent_id = CASE WHEN $2 THEN $3 ELSE parent_id END
+		 WHERE id=$4`,
+		req.Name, req.ParentID != nil, parentID, folderID)
+	if isUniqueViolation(err) {
+		http.Error(w, "❌ "+errFolderExists.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "DB Error (commit): "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Folder updated successfully"})
+}
+
+// DELETE /folders/{id} → delete a folder recursively. Files inside are
+// moved to the trash (restoring puts them in the root), or with
+// ?permanent=true deleted outright, releasing their blobs and quota.
+func (h *FileHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	folderID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid folder id", http.StatusBadRequest)
+		return
+	}
+	permanent := r.URL.Query().Get("permanent") == "true"
+
+	tx, err := h.DB.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	if err := lockFolders(r.Context(), tx, userID); err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var exists bool
+	err = tx.QueryRow(r.Context(),
+		`SELECT true FROM folders WHERE id=$1 AND user_id=$2 FOR UPDATE`, folderID, userID).Scan(&exists)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ Folder not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ids, err := subtree(r.Context(), tx, folderID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var stale []staleBlob
+	if permanent {
+		rows, err := tx.Query(r.Context(),
+			`SELECT id FROM files WHERE folder_id = ANY($1) FOR UPDATE`, ids)
+		if err != nil {
+			http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fileIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
+		if err != nil {
+			http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, id := range fileIDs {
+			blobs, err := h.purgeFile(r.Context(), tx, id)
+			if err != nil {
+				http.Error(w, "❌ Could not delete file: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			stale = append(stale, blobs...)
+		}
+	} else {
+		var trashed int64
+		err = tx.QueryRow(r.Context(),
+			`WITH t AS (
+			     UPDATE files SET is_deleted = true, deleted_at = NOW()
+			     WHERE folder_id = ANY($1) AND NOT is_deleted
+			     RETURNING COALESCE(total_size, size, 0) AS bytes
+			 )
+			 SELECT COALESCE(SUM(bytes), 0) FROM t`, ids,
+		).Scan(&trashed)
+		if err != nil {
+			http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !h.chargesQuota(true) {
+			if err := refundQuota(r.Context(), tx, userID, trashed); err != nil {
+				http.Error(w, "DB Error ("+err.Error()+")", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	// Subfolders cascade; trashed files fall back to the root
+	if _, err := tx.Exec(r.Context(), `DELETE FROM folders WHERE id=$1`, folderID); err != nil {
+		http.Error(w, "DB Error (delete folder): "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "DB Error (commit): "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.dropBlobs(r.Context(), stale)
+
+	h.Audit.Log(r, AuditEvent{Action: AuditFolderDelete, ActorID: &userID, TargetType: "folder", TargetID: auditTarget(folderID),
+		Details: map[string]interface{}{"permanent": permanent, "folders": len(ids)}})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Folder deleted"})
+}
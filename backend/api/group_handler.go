@@ -0,0 +1,363 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/models"
+	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	errMemberNotFound = errors.New("member not found")
+	errLastAdmin      = errors.New("group needs an admin")
+)
+
+// GroupHandler manages groups of users that files can be shared with.
+// Any member can see a group and share files with it; only group admins
+// can change its membership.
+type GroupHandler struct {
+	DB    *pgxpool.Pool
+	Audit *Auditor
+}
+
+// uniqueInts returns ids without duplicates, keeping their order
+func uniqueInts(ids []int) []int {
+	seen := map[int]bool{}
+	out := []int{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// membership returns whether userID is in groupID and whether as an admin
+func membership(ctx context.Context, db *pgxpool.Pool, groupID, userID int) (member, admin bool, err error) {
+	err = db.QueryRow(ctx,
+		`SELECT is_admin FROM group_members WHERE group_id=$1 AND user_id=$2`,
+		groupID, userID).Scan(&admin)
+	if err == pgx.ErrNoRows {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, err
+	}
+	return true, admin, nil
+}
+
+// groupAccess parses the group id in the URL and checks the caller is a
+// member (and an admin if needed). Non-members get a 404.
+func (h *GroupHandler) groupAccess(w http.ResponseWriter, r *http.Request, needAdmin bool) (groupID, userID int, ok bool) {
+	userID, ok = utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return 0, 0, false
+	}
+	groupID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid group id", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	member, admin, err := membership(r.Context(), h.DB, groupID, userID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return 0, 0, false
+	}
+	if !member {
+		http.Error(w, "❌ Group not found", http.StatusNotFound)
+		return 0, 0, false
+	}
+	if needAdmin && !admin {
+		http.Error(w, "❌ Only group admins can do that", http.StatusForbidden)
+		return 0, 0, false
+	}
+	return groupID, userID, true
+}
+
+// POST /groups → create a group ({"name"}); the creator is its first admin
+func (h *GroupHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		http.Error(w, "❌ Group name must be 1-100 characters", http.StatusBadRequest)
+		return
+	}
+
+	g := models.Group{Name: name, CreatedBy: userID, IsAdmin: true, Members: 1}
+	err := pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		err := tx.QueryRow(r.Context(),
+			`INSERT INTO groups (name, created_by) VALUES ($1, $2) RETURNING id, created_at`,
+			name, userID).Scan(&g.ID, &g.CreatedAt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.Context(),
+			`INSERT INTO group_members (group_id, user_id, is_admin) VALUES ($1, $2, true)`,
+			g.ID, userID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(g)
+}
+
+// GET /groups → groups the caller belongs to
+func (h *GroupHandler) GetGroups(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT g.id, g.name, g.created_by, g.created_at, m.is_admin,
+		        (SELECT COUNT(*) FROM group_members WHERE group_id = g.id)::int
+		 FROM groups g
+		 JOIN group_members m ON m.group_id = g.id AND m.user_id = $1
+		 ORDER BY g.name`, userID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	groups, err := pgx.CollectRows(rows, pgx.RowToStructByPos[models.Group])
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// GET /groups/{id} → a group and its members
+func (h *GroupHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, ok := h.groupAccess(w, r, false)
+	if !ok {
+		return
+	}
+
+	var g models.Group
+	err := h.DB.QueryRow(r.Context(),
+		`SELECT g.id, g.name, g.created_by, g.created_at, m.is_admin,
+		        (SELECT COUNT(*) FROM group_members WHERE group_id = g.id)::int
+		 FROM groups g
+		 JOIN group_members m ON m.group_id = g.id AND m.user_id = $2
+		 WHERE g.id=$1`, groupID, userID,
+	).Scan(&g.ID, &g.Name, &g.CreatedBy, &g.CreatedAt, &g.IsAdmin, &g.Members)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT u.id, u.username, m.is_admin, m.added_at
+		 FROM group_members m JOIN users u ON u.id = m.user_id
+		 WHERE m.group_id=$1
+		 ORDER BY m.is_admin DESC, u.username`, groupID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	members, err := pgx.CollectRows(rows, pgx.RowToStructByPos[models.GroupMember])
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		models.Group
+		MemberList []models.GroupMember `json:"members"`
+	}{g, members})
+}
+
+// DELETE /groups/{id} → delete a group; its shares go with it
+func (h *GroupHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, _, ok := h.groupAccess(w, r, true)
+	if !ok {
+		return
+	}
+
+	if _, err := h.DB.Exec(r.Context(), `DELETE FROM groups WHERE id=$1`, groupID); err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Group deleted"})
+}
+
+// POST /groups/{id}/members → add a member by username or email
+// ({"user", "admin"}); existing members are changed with UpdateMember
+func (h *GroupHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, ok := h.groupAccess(w, r, true)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		User  string `json:"user"`
+		Admin bool   `json:"admin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.User) == "" {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	memberID, err := findUser(r.Context(), h.DB, strings.TrimSpace(req.User))
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tag, err := h.DB.Exec(r.Context(),
+		`INSERT INTO group_members (group_id, user_id, is_admin) VALUES ($1, $2, $3)
+		 ON CONFLICT (group_id, user_id) DO NOTHING`,
+		groupID, memberID, req.Admin)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "❌ Already a member", http.StatusConflict)
+		return
+	}
+	h.Audit.Log(r, AuditEvent{Action: AuditMemberAdd, ActorID: &userID, TargetType: "user", TargetID: auditTarget(memberID),
+		Details: map[string]interface{}{"group_id": groupID, "admin": req.Admin}})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "✅ Member added",
+		"user_id": memberID,
+		"admin":   req.Admin,
+	})
+}
+
+// PATCH /groups/{id}/members/{user_id} → promote or demote ({"admin"})
+func (h *GroupHandler) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	groupID, _, ok := h.groupAccess(w, r, true)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Admin *bool `json:"admin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Admin == nil {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	h.changeMember(w, r, groupID, func(tx pgx.Tx, memberID int) (pgconn.CommandTag, error) {
+		return tx.Exec(r.Context(),
+			`UPDATE group_members SET is_admin=$3 WHERE group_id=$1 AND user_id=$2`,
+			groupID, memberID, *req.Admin)
+	}, AuditMemberRole, map[string]interface{}{"group_id": groupID, "admin": *req.Admin}, "✅ Member updated")
+}
+
+// DELETE /groups/{id}/members/{user_id} → remove a member. Admins can
+// remove anyone; any member can remove themselves to leave the group.
+func (h *GroupHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	memberID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid user id", http.StatusBadRequest)
+		return
+	}
+	userID, _ := utils.GetUserID(r.Context())
+
+	groupID, _, ok := h.groupAccess(w, r, memberID != userID)
+	if !ok {
+		return
+	}
+
+	h.changeMember(w, r, groupID, func(tx pgx.Tx, memberID int) (pgconn.CommandTag, error) {
+		return tx.Exec(r.Context(),
+			`DELETE FROM group_members WHERE group_id=$1 AND user_id=$2`, groupID, memberID)
+	}, AuditMemberRemove, map[string]interface{}{"group_id": groupID}, "✅ Member removed")
+}
+
+// changeMember runs change for the member in the URL, refusing to leave
+// the group without an admin while it still has members, and audits it
+// as action
+func (h *GroupHandler) changeMember(w http.ResponseWriter, r *http.Request, groupID int,
+	change func(tx pgx.Tx, memberID int) (pgconn.CommandTag, error),
+	action string, details map[string]interface{}, message string) {
+	memberID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	err = pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		// Serialize membership changes per group so the admin check holds
+		if _, err := tx.Exec(r.Context(), `SELECT 1 FROM groups WHERE id=$1 FOR UPDATE`, groupID); err != nil {
+			return err
+		}
+		tag, err := change(tx, memberID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return errMemberNotFound
+		}
+		var members, admins int
+		err = tx.QueryRow(r.Context(),
+			`SELECT COUNT(*), COUNT(*) FILTER (WHERE is_admin) FROM group_members WHERE group_id=$1`,
+			groupID).Scan(&members, &admins)
+		if err != nil {
+			return err
+		}
+		if members > 0 && admins == 0 {
+			return errLastAdmin
+		}
+		return nil
+	})
+	switch {
+	case err == errMemberNotFound:
+		http.Error(w, "❌ Member not found", http.StatusNotFound)
+		return
+	case err == errLastAdmin:
+		http.Error(w, "❌ A group needs at least one admin", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	userID, _ := utils.GetUserID(r.Context())
+	h.Audit.Log(r, AuditEvent{Action: action, ActorID: &userID, TargetType: "user", TargetID: auditTarget(memberID),
+		Details: details})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
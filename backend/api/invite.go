@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// recipient is a share target resolved to an account
+type recipient struct {
+	recipient string
+	userID    int
+}
+
+// unknownRecipientsError lists recipients that match no account
+type unknownRecipientsError []string
+
+func (e unknownRecipientsError) Error() string {
+	return "Unknown recipients: " + strings.Join(e, ", ")
+}
+
+// findUser looks up an account by username or, for anything containing
+// an @, by email address (case-insensitively). Returns pgx.ErrNoRows if
+// there is none.
+func findUser(ctx context.Context, db *pgxpool.Pool, name string) (int, error) {
+	query := `SELECT id FROM users WHERE username=$1`
+	if strings.Contains(name, "@") {
+		query = `SELECT id FROM users WHERE lower(email)=lower($1)`
+	}
+	var id int
+	err := db.QueryRow(ctx, query, name).Scan(&id)
+	return id, err
+}
+
+// resolveRecipients maps usernames and email addresses to accounts. With
+// invite set, unregistered email addresses are returned (normalised) as
+// invites instead of being reported as unknown.
+func (h *FileHandler) resolveRecipients(ctx context.Context, targetUser int, names []string, invite bool) ([]recipient, []string, error) {
+	var targets []recipient
+	var invites []string
+	var unknown unknownRecipientsError
+	seen := map[string]bool{}
+
+	if targetUser != 0 {
+		var exists bool
+		err := h.DB.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id=$1)`, targetUser).Scan(&exists)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !exists {
+			unknown = append(unknown, fmt.Sprint(targetUser))
+		} else {
+			targets = append(targets, recipient{fmt.Sprint(targetUser), targetUser})
+		}
+	}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		id, err := findUser(ctx, h.DB, name)
+		if err == pgx.ErrNoRows {
+			if addr, perr := mail.ParseAddress(name); invite && perr == nil && addr.Address == name {
+				invites = append(invites, strings.ToLower(name))
+			} else {
+				unknown = append(unknown, name)
+			}
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		targets = append(targets, recipient{name, id})
+	}
+
+	if len(unknown) > 0 {
+		return nil, nil, unknown
+	}
+	return targets, invites, nil
+}
+
+// claimInvites turns pending invites for email into shares for the newly
+// registered userID
+func claimInvites(ctx context.Context, db *pgxpool.Pool, userID int, email string) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO shares (file_id, shared_by, target_user, share_type, shared_at)
+			 SELECT i.file_id, i.invited_by, $1, i.share_type, i.created_at
+			 FROM share_invites i
+			 WHERE i.email = lower($2) AND i.invited_by <> $1
+			 ON CONFLICT (file_id, shared_by, target_user) DO NOTHING`,
+			userID, email)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `DELETE FROM share_invites WHERE email = lower($1)`, email)
+		return err
+	})
+}
+
+// Invite is a pending share for an email address without an account
+type Invite struct {
+	ID        int       `json:"id"`
+	FileID    int       `json:"file_id"`
+	InvitedBy int       `json:"invited_by"`
+	Email     string    `json:"email"`
+	ShareType string    `json:"share_type"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GET /files/{id}/invites → pending invites, same visibility as shares
+func (h *FileHandler) GetInvites(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid file id", http.StatusBadRequest)
+		return
+	}
+	_, perm, ok := h.requirePermission(w, r, fileID, userID, PermReshare)
+	if !ok {
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT id, file_id, invited_by, email, share_type, created_at
+		 FROM share_invites
+		 WHERE file_id=$1 AND ($2 OR invited_by=$3)
+		 ORDER BY created_at DESC`,
+		fileID, perm == permOwner, userID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	invites, err := pgx.CollectRows(rows, pgx.RowToStructByPos[Invite])
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// DELETE /files/{id}/invites/{invite_id} → withdraw a pending invite
+func (h *FileHandler) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid file id", http.StatusBadRequest)
+		return
+	}
+	_, perm, ok := h.requirePermission(w, r, fileID, userID, PermReshare)
+	if !ok {
+		return
+	}
+
+	tag, err := h.DB.Exec(r.Context(),
+		`DELETE FROM share_invites WHERE id=$1 AND file_id=$2 AND ($3 OR invited_by=$4)`,
+		mux.Vars(r)["invite_id"], fileID, perm == permOwner, userID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "❌ Invite not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Invite withdrawn"})
+}
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/storage"
+	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// ShareLink is a public download link as shown to its owner. The token
+// itself is only returned once, when the link is created.
+type ShareLink struct {
+	ID            int        `json:"id"`
+	FileID        int        `json:"file_id"`
+	Token         string     `json:"token,omitempty"`
+	URL           string     `json:"url,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	MaxDownloads  *int       `json:"max_downloads"`
+	DownloadCount int        `json:"download_count"`
+	HasPassword   bool       `json:"has_password"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// POST /files/{id}/links → mint a public link
+// ({"expires_at", "max_downloads", "password"}, all optional)
+func (h *FileHandler) CreateLink(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !h.requireVerified(w, r, userID) {
+		return
+	}
+	fileID, ok := h.ownedFile(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ExpiresAt    *time.Time `json:"expires_at"`
+		MaxDownloads *int       `json:"max_downloads"`
+		Password     string     `json:"password"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "❌ expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+	if req.MaxDownloads != nil && *req.MaxDownloads <= 0 {
+		http.Error(w, "❌ max_downloads must be positive", http.StatusBadRequest)
+		return
+	}
+
+	var passwordHash *string
+	if req.Password != "" {
+		hashed, err := utils.HashPassword(req.Password)
+		if err != nil {
+			http.Error(w, "❌ Failed to hash password", http.StatusInternalServerError)
+			return
+		}
+		passwordHash = &hashed
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		http.Error(w, "❌ Could not create link", http.StatusInternalServerError)
+		return
+	}
+
+	link := ShareLink{
+		FileID:       fileID,
+		Token:        token,
+		URL:          "/s/" + token,
+		ExpiresAt:    req.ExpiresAt,
+		MaxDownloads: req.MaxDownloads,
+		HasPassword:  passwordHash != nil,
+	}
+	err = h.DB.QueryRow(r.Context(),
+		`INSERT INTO share_links (file_id, created_by, token_hash, expires_at, max_downloads, password_hash)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING id, created_at`,
+		fileID, userID, utils.HashToken(token), req.ExpiresAt, req.MaxDownloads, passwordHash,
+	).Scan(&link.ID, &link.CreatedAt)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditLinkCreate, ActorID: &userID, TargetType: "file", TargetID: auditTarget(fileID),
+		Details: map[string]interface{}{"link_id": link.ID, "expires_at": link.ExpiresAt,
+			"max_downloads": link.MaxDownloads, "password": link.HasPassword}})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+// GET /files/{id}/links → the owner's active links for a file
+func (h *FileHandler) GetLinks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fileID, ok := h.ownedFile(w, r, userID)
+	if !ok {
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT id, file_id, expires_at, max_downloads, download_count, password_hash IS NOT NULL, created_at
+		 FROM share_links
+		 WHERE file_id=$1 AND revoked_at IS NULL
+		 ORDER BY created_at DESC`, fileID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		var l ShareLink
+		if err := rows.Scan(&l.ID, &l.FileID, &l.ExpiresAt, &l.MaxDownloads,
+			&l.DownloadCount, &l.HasPassword, &l.CreatedAt); err != nil {
+			http.Error(w, "Scan Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		links = append(links, l)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+// DELETE /files/{id}/links/{link_id} → revoke a link
+func (h *FileHandler) RevokeLink(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fileID, ok := h.ownedFile(w, r, userID)
+	if !ok {
+		return
+	}
+	linkID, err := strconv.Atoi(mux.Vars(r)["link_id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid link id", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := h.DB.Exec(r.Context(),
+		`UPDATE share_links SET revoked_at = NOW()
+		 WHERE id=$1 AND file_id=$2 AND revoked_at IS NULL`,
+		linkID, fileID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "❌ Link not found", http.StatusNotFound)
+		return
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditLinkRevoke, ActorID: &userID, TargetType: "file", TargetID: auditTarget(fileID),
+		Details: map[string]interface{}{"link_id": linkID}})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Link revoked"})
+}
+
+// Link downloads are metered in bytes: a link with max_downloads N may
+// send N times the file's size, however the client splits that into
+// ranges. download_count is bytes_served in whole files, rounded up.
+
+// rangeBytes is how many bytes of a size-byte file r asks for: the sum of
+// its ranges (overlaps counted twice), or the whole file without a usable
+// Range header
+func rangeBytes(r *http.Request, size int64) int64 {
+	spec, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !ok || r.Header.Get("If-Range") != "" {
+		return size
+	}
+	var total int64
+	for _, part := range strings.Split(spec, ",") {
+		first, last, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return size
+		}
+		var n int64
+		if first == "" {
+			// Suffix range: the last N bytes
+			suffix, err := strconv.ParseInt(last, 10, 64)
+			if err != nil {
+				return size
+			}
+			n = min(suffix, size)
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start >= size {
+				return size
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return size
+				}
+				end = min(end, size-1)
+			}
+			n = end - start + 1
+		}
+		total += n
+	}
+	return max(min(total, size), 1)
+}
+
+// reserveLinkBytes sets aside n bytes of the link's allowance before
+// serving, so parallel requests can't go over it together. It fails once
+// the allowance is used up.
+func (h *FileHandler) reserveLinkBytes(ctx context.Context, linkID int, n, size int64) (bool, error) {
+	tag, err := h.DB.Exec(ctx,
+		`UPDATE share_links SET bytes_served = bytes_served + $2
+		 WHERE id=$1 AND (max_downloads IS NULL OR bytes_served < max_downloads::bigint * $3)`,
+		linkID, n, size)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// settleLinkBytes gives back the part of a reservation that wasn't sent
+// (all of it for 304s, errors and missing blobs) and updates download_count
+func (h *FileHandler) settleLinkBytes(ctx context.Context, linkID int, unused, size int64) {
+	_, err := h.DB.Exec(ctx,
+		`UPDATE share_links SET bytes_served = GREATEST(bytes_served - $2, 0),
+		        download_count = CEIL(GREATEST(bytes_served - $2, 0)::numeric / $3)
+		 WHERE id=$1`, linkID, unused, size)
+	if err != nil {
+		log.Printf("⚠️ settling downloads of link %d failed: %v", linkID, err)
+	}
+}
+
+// GET /s/{token} → public, unauthenticated download. Password protected
+// links take the password in the X-Link-Password header, or as a
+// "password" form field via POST.
+func (h *FileHandler) PublicDownload(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	var linkID, fileID, maxDownloads int
+	var hasMax, live bool
+	var bytesServed int64
+	var passwordHash *string
+	var m blobMeta
+	err := h.DB.QueryRow(r.Context(),
+		`SELECT l.id, l.file_id, COALESCE(l.max_downloads, 0), l.max_downloads IS NOT NULL, l.bytes_served, l.password_hash,
+		        l.revoked_at IS NULL AND (l.expires_at IS NULL OR l.expires_at > NOW()) AND NOT f.is_deleted,
+		        f.filename, COALESCE(f.filepath, ''), f.file_hash, COALESCE(f.mime_type, ''), COALESCE(f.size, 0),
+		        COALESCE(f.updated_at, f.uploaded_at)
+		 FROM share_links l JOIN files f ON f.id = l.file_id
+		 WHERE l.token_hash=$1`,
+		utils.HashToken(token),
+	).Scan(&linkID, &fileID, &maxDownloads, &hasMax, &bytesServed, &passwordHash, &live,
+		&m.Filename, &m.Key, &m.Hash, &m.MimeType, &m.Size, &m.ModTime)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ Link not found or expired", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Without the password, a protected link says nothing about its state
+	if passwordHash != nil {
+		password := r.Header.Get("X-Link-Password")
+		if password == "" && r.Method == http.MethodPost {
+			password = r.FormValue("password")
+		}
+		if password == "" || !utils.CheckPasswordHash(password, *passwordHash) {
+			http.Error(w, "❌ Password required", http.StatusUnauthorized)
+			return
+		}
+	}
+	if !live {
+		http.Error(w, "❌ Link not found or expired", http.StatusNotFound)
+		return
+	}
+
+	// Same resolution as DownloadFile, so legacy uploads/ rows work too
+	m.Key = storageKey(m.Key)
+	if m.Size <= 0 {
+		// Old rows may not have a size recorded; metering needs it
+		info, err := h.Store.Stat(r.Context(), m.Key)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "❌ File content missing", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "❌ Could not open file: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m.Size = info.Size
+	}
+	unit := max(m.Size, 1) // an empty file still costs something
+	if hasMax && bytesServed >= int64(maxDownloads)*unit {
+		http.Error(w, "❌ Download limit reached", http.StatusGone)
+		return
+	}
+
+	var reserved int64
+	if r.Method != http.MethodHead {
+		reserved = rangeBytes(r, unit)
+		ok, err := h.reserveLinkBytes(r.Context(), linkID, reserved, unit)
+		if err != nil {
+			http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "❌ Download limit reached", http.StatusGone)
+			return
+		}
+	}
+
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	served, sent := h.serveDownload(w, r, fileID, m, downloadSource{LinkID: &linkID})
+	if reserved > 0 {
+		// Multipart framing isn't charged; an empty file costs one byte
+		var charged int64
+		if served {
+			charged = min(max(sent, 1), reserved)
+		}
+		h.settleLinkBytes(context.WithoutCancel(r.Context()), linkID, reserved-charged, unit)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Progressive lockout: every LockoutThreshold-th consecutive failed login
+// locks the account, for lockoutBase the first time and twice as long
+// each time after, up to lockoutMax. A successful login resets the count.
+const (
+	lockoutBase = time.Minute
+	lockoutMax  = time.Hour
+)
+
+// lockoutDuration is how long to lock after the given number of
+// consecutive failures (0 = don't lock)
+func lockoutDuration(failures, threshold int) time.Duration {
+	if threshold <= 0 || failures < threshold || failures%threshold != 0 {
+		return 0
+	}
+	d := lockoutBase
+	for n := failures / threshold; n > 1 && d < lockoutMax; n-- {
+		d *= 2
+	}
+	if d > lockoutMax {
+		d = lockoutMax
+	}
+	return d
+}
+
+// recordLoginFailure counts a failed login for userID and locks the
+// account when it crosses the threshold
+func (h *UserHandler) recordLoginFailure(r *http.Request, userID int) {
+	var failures int
+	err := h.DB.QueryRow(r.Context(),
+		`UPDATE users SET failed_logins = failed_logins + 1 WHERE id=$1 RETURNING failed_logins`, userID,
+	).Scan(&failures)
+	if err != nil {
+		log.Printf("⚠️ recording failed login for user %d failed: %v", userID, err)
+		return
+	}
+
+	d := lockoutDuration(failures, h.LockoutThreshold)
+	if d == 0 {
+		return
+	}
+	until := h.now().Add(d)
+	if _, err := h.DB.Exec(r.Context(),
+		`UPDATE users SET locked_until=$2 WHERE id=$1`, userID, until); err != nil {
+		log.Printf("⚠️ locking user %d failed: %v", userID, err)
+		return
+	}
+	h.Audit.Log(r, AuditEvent{Action: AuditAccountLocked, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID),
+		Details: map[string]interface{}{"failures": failures, "locked_until": until}})
+}
+
+// clearLoginFailures resets the count after a complete login
+func clearLoginFailures(ctx context.Context, tx pgx.Tx, userID int) error {
+	_, err := tx.Exec(ctx,
+		`UPDATE users SET failed_logins = 0, locked_until = NULL
+		 WHERE id=$1 AND (failed_logins <> 0 OR locked_until IS NOT NULL)`, userID)
+	return err
+}
@@ -0,0 +1,453 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+	"github.com/jackc/pgx/v5"
+)
+
+// Two-factor login with TOTP. Enrollment is two steps: /mfa/enroll hands
+// out a secret (and otpauth:// URI for a QR code), /mfa/confirm switches
+// it on once the user proves their app produces matching codes. From then
+// on /login answers with a short-lived challenge token instead of session
+// tokens, and /login/mfa trades it plus a code for the real ones.
+
+const (
+	mfaChallengeTTL    = 5 * time.Minute
+	mfaSkew            = 1 // accept codes one step either side of now
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+var (
+	errMFAEnabled     = errors.New("mfa already enabled")
+	errMFANotEnrolled = errors.New("mfa not enrolled")
+	errMFACode        = errors.New("invalid mfa code")
+
+	errAccountDisabled = errors.New("account disabled")
+)
+
+// now is the handler's clock; tests can pin it with UserHandler.Now
+func (h *UserHandler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
+
+// newRecoveryCodes returns fresh codes formatted "xxxxx-xxxxx"
+func newRecoveryCodes() ([]string, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 8)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(enc.EncodeToString(buf))[:recoveryCodeLength]
+		codes[i] = c[:recoveryCodeLength/2] + "-" + c[recoveryCodeLength/2:]
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode drops the separators people type or paste
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// replaceRecoveryCodes swaps the user's recovery codes for new ones in tx
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int) ([]string, error) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id=$1`, userID); err != nil {
+		return nil, err
+	}
+	for _, c := range codes {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, utils.HashToken(normalizeRecoveryCode(c))); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// useTOTP checks a TOTP code against secret and burns its time step, so
+// the same code can't be replayed while it is still current
+func (h *UserHandler) useTOTP(ctx context.Context, q pgx.Tx, userID int, secret, code string) (bool, error) {
+	step, ok := utils.ValidateTOTP(secret, code, h.now(), mfaSkew)
+	if !ok {
+		return false, nil
+	}
+	tag, err := q.Exec(ctx,
+		`UPDATE users SET mfa_last_step=$2
+		 WHERE id=$1 AND (mfa_last_step IS NULL OR mfa_last_step < $2)`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// secondFactor checks code as a TOTP code or, failing that, as an unused
+// recovery code (which is then spent). method is "totp" or "recovery".
+func (h *UserHandler) secondFactor(ctx context.Context, tx pgx.Tx, userID int, code string) (method string, ok bool, err error) {
+	var secret string
+	err = tx.QueryRow(ctx,
+		`SELECT mfa_secret FROM users WHERE id=$1 AND mfa_enabled_at IS NOT NULL FOR UPDATE`, userID,
+	).Scan(&secret)
+	if err == pgx.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	if ok, err := h.useTOTP(ctx, tx, userID, secret, code); err != nil || ok {
+		return "totp", ok, err
+	}
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE mfa_recovery_codes SET used_at = NOW()
+		 WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`,
+		userID, utils.HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return "", false, err
+	}
+	return "recovery", tag.RowsAffected() == 1, nil
+}
+
+// GET /mfa → whether MFA is on and how many recovery codes are left
+func (h *UserHandler) GetMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var enabled bool
+	var left int
+	err := h.DB.QueryRow(r.Context(),
+		`SELECT u.mfa_enabled_at IS NOT NULL,
+		        (SELECT COUNT(*) FROM mfa_recovery_codes c WHERE c.user_id = u.id AND c.used_at IS NULL)
+		 FROM users u WHERE u.id=$1`, userID,
+	).Scan(&enabled, &left)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"enabled": enabled, "recovery_codes_left": left})
+}
+
+// POST /mfa/enroll → start enrollment: a new secret and its otpauth:// URI.
+// Nothing changes for login until /mfa/confirm.
+func (h *UserHandler) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		http.Error(w, "❌ Could not create secret", http.StatusInternalServerError)
+		return
+	}
+
+	var email string
+	err = h.DB.QueryRow(r.Context(),
+		`UPDATE users SET mfa_secret=$2, mfa_last_step=NULL
+		 WHERE id=$1 AND mfa_enabled_at IS NULL
+		 RETURNING email`, userID, secret,
+	).Scan(&email)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ MFA is already enabled", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(h.MFAIssuer, email, secret),
+	})
+}
+
+// POST /mfa/confirm → finish enrollment with a code from the app
+// ({"code"}); returns the recovery codes, shown only this once
+func (h *UserHandler) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	code, ok := mfaCodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var codes []string
+	err := pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		var secret *string
+		var enabled bool
+		err := tx.QueryRow(r.Context(),
+			`SELECT mfa_secret, mfa_enabled_at IS NOT NULL FROM users WHERE id=$1 FOR UPDATE`, userID,
+		).Scan(&secret, &enabled)
+		if err != nil {
+			return err
+		}
+		if enabled {
+			return errMFAEnabled
+		}
+		if secret == nil {
+			return errMFANotEnrolled
+		}
+		ok, err := h.useTOTP(r.Context(), tx, userID, *secret, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errMFACode
+		}
+		if _, err := tx.Exec(r.Context(),
+			`UPDATE users SET mfa_enabled_at = NOW() WHERE id=$1`, userID); err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(r.Context(), tx, userID)
+		return err
+	})
+	switch err {
+	case nil:
+	case errMFAEnabled:
+		http.Error(w, "❌ MFA is already enabled", http.StatusConflict)
+		return
+	case errMFANotEnrolled:
+		http.Error(w, "❌ Call /mfa/enroll first", http.StatusConflict)
+		return
+	case errMFACode:
+		http.Error(w, "❌ Invalid code", http.StatusUnauthorized)
+		return
+	default:
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditMFAEnable, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID)})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "✅ MFA enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// mfaCodeRequest decodes {"code"} for endpoints that need a fresh second
+// factor before changing MFA settings
+func mfaCodeRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return "", false
+	}
+	return req.Code, true
+}
+
+// POST /mfa/disable → turn MFA off ({"code"}: TOTP or recovery code)
+func (h *UserHandler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	code, ok := mfaCodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		_, ok, err := h.secondFactor(r.Context(), tx, userID, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errMFACode
+		}
+		return clearMFA(r.Context(), tx, userID)
+	})
+	if err == errMFACode {
+		http.Error(w, "❌ Invalid code", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditMFADisable, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ MFA disabled"})
+}
+
+// POST /mfa/recovery-codes → replace all recovery codes ({"code"}: a TOTP
+// code, so a leaked recovery code can't be used to mint more)
+func (h *UserHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	code, ok := mfaCodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var codes []string
+	err := pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		var secret string
+		err := tx.QueryRow(r.Context(),
+			`SELECT mfa_secret FROM users WHERE id=$1 AND mfa_enabled_at IS NOT NULL FOR UPDATE`, userID,
+		).Scan(&secret)
+		if err == pgx.ErrNoRows {
+			return errMFANotEnrolled
+		} else if err != nil {
+			return err
+		}
+		ok, err := h.useTOTP(r.Context(), tx, userID, secret, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errMFACode
+		}
+		codes, err = replaceRecoveryCodes(r.Context(), tx, userID)
+		return err
+	})
+	switch err {
+	case nil:
+	case errMFANotEnrolled:
+		http.Error(w, "❌ MFA is not enabled", http.StatusConflict)
+		return
+	case errMFACode:
+		http.Error(w, "❌ Invalid code", http.StatusUnauthorized)
+		return
+	default:
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+// clearMFA switches MFA off for a user and drops their recovery codes
+func clearMFA(ctx context.Context, tx pgx.Tx, userID int) error {
+	if _, err := tx.Exec(ctx,
+		`UPDATE users SET mfa_secret=NULL, mfa_enabled_at=NULL, mfa_last_step=NULL WHERE id=$1`, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id=$1`, userID)
+	return err
+}
+
+// POST /login/mfa → second login step ({"mfa_token", "code"}, where code
+// is a TOTP code or a recovery code); returns the same tokens as /login
+func (h *UserHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+	userID, jti, err := utils.ValidateChallengeToken(req.MFAToken, "mfa", h.Secret)
+	if err != nil {
+		http.Error(w, "❌ Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	// Challenge tokens are single-use, right or wrong, so guessing codes
+	// costs a password check per attempt
+	tag, err := h.DB.Exec(r.Context(),
+		`INSERT INTO revoked_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3)
+		 ON CONFLICT (jti) DO NOTHING`, jti, userID, time.Now().Add(mfaChallengeTTL))
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "❌ Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	var resp tokenResponse
+	var method string
+	err = pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		var ok bool
+		var err error
+		method, ok, err = h.secondFactor(r.Context(), tx, userID, req.Code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errMFACode
+		}
+
+		var email, role string
+		var disabled bool
+		err = tx.QueryRow(r.Context(),
+			`SELECT email, COALESCE(role, 'user'), disabled_at IS NOT NULL FROM users WHERE id=$1`, userID,
+		).Scan(&email, &role, &disabled)
+		if err != nil {
+			return err
+		}
+		if disabled {
+			return errAccountDisabled
+		}
+		if err := clearLoginFailures(r.Context(), tx, userID); err != nil {
+			return err
+		}
+		resp, err = h.issueTokens(r.Context(), tx, r, userID, email, role, "")
+		return err
+	})
+	switch err {
+	case nil:
+	case errMFACode:
+		h.Audit.Log(r, AuditEvent{Action: AuditLoginFailed, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID),
+			Details: map[string]interface{}{"reason": "wrong mfa code"}})
+		h.recordLoginFailure(r, userID)
+		http.Error(w, "❌ Invalid code, log in again", http.StatusUnauthorized)
+		return
+	case errAccountDisabled:
+		http.Error(w, "❌ Account disabled", http.StatusForbidden)
+		return
+	default:
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditLogin, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID),
+		Details: map[string]interface{}{"mfa": method}})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// mfaTx stands in for the database in the second-factor checks. It
+// understands just the statements useTOTP and secondFactor run against
+// one user's row and recovery codes.
+type mfaTx struct {
+	pgx.Tx // unused methods panic
+
+	secret   string
+	lastStep *int64
+	codes    map[string]bool // code hash → used
+}
+
+type mfaRow struct {
+	secret string
+	err    error
+}
+
+func (r mfaRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*string) = r.secret
+	return nil
+}
+
+func (tx *mfaTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	if !strings.Contains(sql, "SELECT mfa_secret FROM users") {
+		return mfaRow{err: fmt.Errorf("mfaTx: unexpected query %q", sql)}
+	}
+	if tx.secret == "" {
+		return mfaRow{err: pgx.ErrNoRows}
+	}
+	return mfaRow{secret: tx.secret}
+}
+
+func (tx *mfaTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	switch {
+	case strings.Contains(sql, "UPDATE users SET mfa_last_step"):
+		step := args[1].(int64)
+		if tx.lastStep != nil && *tx.lastStep >= step {
+			return pgconn.NewCommandTag("UPDATE 0"), nil
+		}
+		tx.lastStep = &step
+		return pgconn.NewCommandTag("UPDATE 1"), nil
+	case strings.Contains(sql, "UPDATE mfa_recovery_codes SET used_at"):
+		hash := args[1].(string)
+		if used, ok := tx.codes[hash]; !ok || used {
+			return pgconn.NewCommandTag("UPDATE 0"), nil
+		}
+		tx.codes[hash] = true
+		return pgconn.NewCommandTag("UPDATE 1"), nil
+	}
+	return pgconn.CommandTag{}, fmt.Errorf("mfaTx: unexpected statement %q", sql)
+}
+
+const mfaTestSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+// mfaClock is a settable clock for UserHandler.Now
+type mfaClock struct{ t time.Time }
+
+func (c *mfaClock) now() time.Time { return c.t }
+
+func newMFATest(t *testing.T) (*UserHandler, *mfaClock, *mfaTx) {
+	t.Helper()
+	clock := &mfaClock{t: time.Date(2024, 5, 1, 12, 0, 10, 0, time.UTC)}
+	h := &UserHandler{Now: clock.now}
+	tx := &mfaTx{secret: mfaTestSecret, codes: map[string]bool{}}
+	return h, clock, tx
+}
+
+func totpAt(t *testing.T, at time.Time, delta int64) string {
+	t.Helper()
+	code, err := utils.TOTPCode(mfaTestSecret, utils.TOTPStep(at)+delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestUseTOTPSkew(t *testing.T) {
+	for _, delta := range []int64{-1, 0, 1} {
+		h, clock, tx := newMFATest(t)
+		ok, err := h.useTOTP(context.Background(), tx, 1, mfaTestSecret, totpAt(t, clock.t, delta))
+		if err != nil || !ok {
+			t.Errorf("code %+d steps from now: ok=%v err=%v", delta, ok, err)
+		}
+	}
+	for _, delta := range []int64{-2, 2} {
+		h, clock, tx := newMFATest(t)
+		ok, err := h.useTOTP(context.Background(), tx, 1, mfaTestSecret, totpAt(t, clock.t, delta))
+		if err != nil || ok {
+			t.Errorf("code %+d steps from now accepted: ok=%v err=%v", delta, ok, err)
+		}
+		if tx.lastStep != nil {
+			t.Errorf("rejected code %+d burned step %d", delta, *tx.lastStep)
+		}
+	}
+}
+
+func TestUseTOTPReplay(t *testing.T) {
+	h, clock, tx := newMFATest(t)
+	ctx := context.Background()
+	code := totpAt(t, clock.t, 0)
+
+	if ok, err := h.useTOTP(ctx, tx, 1, mfaTestSecret, code); err != nil || !ok {
+		t.Fatalf("first use: ok=%v err=%v", ok, err)
+	}
+	if ok, _ := h.useTOTP(ctx, tx, 1, mfaTestSecret, code); ok {
+		t.Fatal("same code accepted twice in its step")
+	}
+
+	// Still inside the skew window a step later, but already spent
+	clock.t = clock.t.Add(utils.TOTPPeriod * time.Second)
+	if ok, _ := h.useTOTP(ctx, tx, 1, mfaTestSecret, code); ok {
+		t.Fatal("spent code accepted in the next step")
+	}
+	// The new step's own code is fine
+	if ok, err := h.useTOTP(ctx, tx, 1, mfaTestSecret, totpAt(t, clock.t, 0)); err != nil || !ok {
+		t.Fatalf("next step: ok=%v err=%v", ok, err)
+	}
+	// Once a later step is used, an earlier unused one in the window is dead
+	if ok, _ := h.useTOTP(ctx, tx, 1, mfaTestSecret, totpAt(t, clock.t, -1)); ok {
+		t.Fatal("code older than the last used step accepted")
+	}
+}
+
+func TestSecondFactor(t *testing.T) {
+	h, clock, tx := newMFATest(t)
+	ctx := context.Background()
+
+	method, ok, err := h.secondFactor(ctx, tx, 1, totpAt(t, clock.t, 0))
+	if err != nil || !ok || method != "totp" {
+		t.Fatalf("totp: method=%q ok=%v err=%v", method, ok, err)
+	}
+	if _, ok, _ := h.secondFactor(ctx, tx, 1, totpAt(t, clock.t, 0)); ok {
+		t.Fatal("replayed totp code accepted")
+	}
+
+	// Without MFA enabled nothing passes
+	tx.secret = ""
+	if _, ok, err := h.secondFactor(ctx, tx, 1, totpAt(t, clock.t, 1)); ok || err != nil {
+		t.Fatalf("mfa off: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestRecoveryCodesSingleUse(t *testing.T) {
+	h, _, tx := newMFATest(t)
+	ctx := context.Background()
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != recoveryCodeLength+1 || c[recoveryCodeLength/2] != '-' || seen[c] {
+			t.Fatalf("bad or repeated code %q", c)
+		}
+		seen[c] = true
+		tx.codes[utils.HashToken(normalizeRecoveryCode(c))] = false
+	}
+
+	// Typed in upper case with a space instead of the dash
+	typed := strings.ToUpper(strings.Replace(codes[0], "-", " ", 1))
+	method, ok, err := h.secondFactor(ctx, tx, 1, typed)
+	if err != nil || !ok || method != "recovery" {
+		t.Fatalf("recovery code: method=%q ok=%v err=%v", method, ok, err)
+	}
+	if _, ok, _ := h.secondFactor(ctx, tx, 1, codes[0]); ok {
+		t.Fatal("recovery code accepted twice")
+	}
+
+	// Other codes are unaffected
+	if _, ok, _ := h.secondFactor(ctx, tx, 1, codes[1]); !ok {
+		t.Fatal("second recovery code refused")
+	}
+	if _, ok, _ := h.secondFactor(ctx, tx, 1, "aaaaa-bbbbb"); ok {
+		t.Fatal("unknown recovery code accepted")
+	}
+	left := 0
+	for _, used := range tx.codes {
+		if !used {
+			left++
+		}
+	}
+	if left != recoveryCodeCount-2 {
+		t.Fatalf("%d codes left, want %d", left, recoveryCodeCount-2)
+	}
+}
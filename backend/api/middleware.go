@@ -1,42 +1,185 @@
 package api
 
 import (
+	"log"
 	"net/http"
 	"strings"
 
 	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// AuthMiddleware validates JWT and attaches user_id to request context
-func AuthMiddleware(next http.Handler, secret string) http.Handler {
+// Roles stored in users.role
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// bearerClaims validates the Bearer JWT on r and returns its claims,
+// or a message for the 401 response
+func bearerClaims(r *http.Request, secret string) (map[string]interface{}, string) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, "❌ Missing Authorization header"
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		return nil, "❌ Invalid Authorization format"
+	}
+	tokenStr := parts[1]
+
+	claims, err := utils.ValidateAndGetClaims(tokenStr, secret)
+	if err != nil {
+		return nil, "❌ Invalid token: " + err.Error()
+	}
+	if _, ok := claims["user_id"].(float64); !ok {
+		return nil, "❌ Invalid token payload"
+	}
+	return claims, ""
+}
+
+// Auth validates the Bearer JWT against the users table: on top of the
+// signature check it rejects logged-out tokens and deleted or disabled
+// accounts straight away (not when their token expires) and puts the
+// user's id and current role in the context. It is the only way routes
+// are authenticated.
+type Auth struct {
+	DB     *pgxpool.Pool
+	Secret string
+}
+
+// Middleware authenticates the request and attaches user_id and role.
+// Only login sessions are accepted; see Scoped for API keys.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return a.Scoped("", next)
+}
+
+// Scoped is Middleware that also accepts API keys carrying scope
+func (a *Auth) Scoped(scope string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "❌ Missing Authorization header", http.StatusUnauthorized)
+		if key, ok := apiKeyFromRequest(r); ok {
+			a.serveAPIKey(w, r, key, scope, next)
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			http.Error(w, "❌ Invalid Authorization format", http.StatusUnauthorized)
+		claims, msg := bearerClaims(r, a.Secret)
+		if claims == nil {
+			http.Error(w, msg, http.StatusUnauthorized)
 			return
 		}
-		tokenStr := parts[1]
+		userID := int(claims["user_id"].(float64))
+
+		jti, _ := claims["jti"].(string)
+		iat, _ := claims["iat"].(float64)
 
-		claims, err := utils.ValidateAndGetClaims(tokenStr, secret)
-		if err != nil {
-			http.Error(w, "❌ Invalid token: "+err.Error(), http.StatusUnauthorized)
+		// The role claim may be stale; the database has the final say. A
+		// token is also dead once logged out (its jti is on the denylist)
+		// or when issued at or before a "log out everywhere".
+		var role string
+		var disabled, revoked bool
+		err := a.DB.QueryRow(r.Context(),
+			`SELECT COALESCE(u.role, 'user'), u.disabled_at IS NOT NULL,
+			        EXISTS (SELECT 1 FROM revoked_tokens WHERE jti=$2)
+			        OR COALESCE(u.sessions_revoked_at >= to_timestamp($3), false)
+			 FROM users u WHERE u.id=$1`, userID, jti, iat,
+		).Scan(&role, &disabled, &revoked)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "❌ Invalid token: unknown user", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		// put user_id into context
-		userID, ok := claims["user_id"].(float64)
-		if !ok {
-			http.Error(w, "❌ Invalid token payload", http.StatusUnauthorized)
+		if disabled {
+			http.Error(w, "❌ Account disabled", http.StatusForbidden)
+			return
+		}
+		if revoked {
+			http.Error(w, "❌ Invalid token: revoked", http.StatusUnauthorized)
 			return
 		}
 
-		ctx := utils.WithUserID(r.Context(), int(userID))
+		ctx := utils.WithUserID(r.Context(), userID)
+		ctx = utils.WithRole(ctx, role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// apiKeyFromRequest finds an API key in X-API-Key or as the Bearer token
+func apiKeyFromRequest(r *http.Request) (string, bool) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = utils.ExtractToken(r.Header.Get("Authorization"))
+	}
+	return key, strings.HasPrefix(key, apiKeyPrefix)
+}
+
+// serveAPIKey authenticates an API key request for a route needing scope
+func (a *Auth) serveAPIKey(w http.ResponseWriter, r *http.Request, key, scope string, next http.Handler) {
+	var keyID, userID int
+	var scopes []string
+	var role string
+	var disabled bool
+	err := a.DB.QueryRow(r.Context(),
+		`SELECT k.id, k.user_id, k.scopes, COALESCE(u.role, 'user'), u.disabled_at IS NOT NULL
+		 FROM api_keys k JOIN users u ON u.id = k.user_id
+		 WHERE k.key_hash=$1 AND k.revoked_at IS NULL
+		   AND (k.expires_at IS NULL OR k.expires_at > NOW())`,
+		utils.HashToken(key),
+	).Scan(&keyID, &userID, &scopes, &role, &disabled)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ Invalid API key", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if disabled {
+		http.Error(w, "❌ Account disabled", http.StatusForbidden)
+		return
+	}
+	if scope == "" {
+		http.Error(w, "❌ API keys can't be used here; log in instead", http.StatusForbidden)
+		return
+	}
+	if !hasScope(scopes, scope) {
+		http.Error(w, "❌ API key lacks the "+scope+" scope", http.StatusForbidden)
+		return
+	}
+
+	// Minute resolution is plenty and saves a write per request
+	if _, err := a.DB.Exec(r.Context(),
+		`UPDATE api_keys SET last_used_at = NOW()
+		 WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`,
+		keyID); err != nil {
+		log.Printf("⚠️ updating last_used_at of API key %d failed: %v", keyID, err)
+	}
+
+	ctx := utils.WithUserID(r.Context(), userID)
+	ctx = utils.WithRole(ctx, role)
+	ctx = utils.WithScopes(ctx, scopes)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// hasScope reports whether scopes includes scope
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Admin is Middleware restricted to users with the admin role
+func (a *Auth) Admin(next http.Handler) http.Handler {
+	return a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if role, _ := utils.GetRole(r.Context()); role != RoleAdmin {
+			http.Error(w, "❌ Admins only", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// MIME policies for uploads whose content doesn't match their declared type
+const (
+	MIMEPolicyOff    = "off"    // trust the declared type
+	MIMEPolicyWarn   = "warn"   // store the sniffed type and log the mismatch
+	MIMEPolicyReject = "reject" // refuse the upload
+)
+
+var errMIMEMismatch = errors.New("file content does not match its declared type")
+
+// sniffLen is how much of the file http.DetectContentType looks at
+const sniffLen = 512
+
+// baseMIME strips parameters and lowercases a media type
+func baseMIME(t string) string {
+	if mt, _, err := mime.ParseMediaType(t); err == nil {
+		return mt
+	}
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// mimeMaxLen is the width of files.mime_type
+const mimeMaxLen = 100
+
+// claimedMIME parses a declared or extension-implied type, returning ""
+// if it's malformed or too long to store
+func claimedMIME(t string) string {
+	mt, _, err := mime.ParseMediaType(t)
+	if err != nil || !strings.Contains(mt, "/") || len(mt) > mimeMaxLen {
+		return ""
+	}
+	return mt
+}
+
+// mimeRefinements lists, per sniffed type, the more specific types a
+// compatible claim may replace it with (e.g. JSON sniffs as text/plain).
+// Anything else, text/html or image/svg+xml included, keeps the sniffed
+// type so a claim can't turn plain content into something a browser runs.
+var mimeRefinements = map[string][]string{
+	"text/plain": {"text/csv", "text/markdown", "text/tab-separated-values", "text/calendar",
+		"application/json", "application/yaml", "application/x-yaml", "application/toml", "application/sql"},
+	"text/xml": {"application/xml", "application/atom+xml", "application/rss+xml"},
+	"application/zip": {"application/epub+zip", "application/java-archive",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		"application/vnd.oasis.opendocument.text",
+		"application/vnd.oasis.opendocument.spreadsheet",
+		"application/vnd.oasis.opendocument.presentation"},
+	"application/octet-stream": {"application/msword", "application/vnd.ms-excel", "application/vnd.ms-powerpoint",
+		"application/x-7z-compressed", "application/x-tar", "application/x-sqlite3"},
+}
+
+// refinesMIME reports whether declared is an allowed, more specific name
+// for sniffed content
+func refinesMIME(sniffed, declared string) bool {
+	return slices.Contains(mimeRefinements[sniffed], declared)
+}
+
+// textLike reports whether a declared type is plausible for text content
+func textLike(t string) bool {
+	if strings.HasPrefix(t, "text/") || strings.HasSuffix(t, "+xml") || strings.HasSuffix(t, "+json") {
+		return true
+	}
+	switch t {
+	case "application/json", "application/xml", "application/javascript", "application/x-javascript",
+		"application/x-yaml", "application/yaml", "application/x-sh", "application/sql", "application/toml":
+		return true
+	}
+	return false
+}
+
+// compatibleMIME reports whether sniffed content could legitimately be
+// declared as the given type
+func compatibleMIME(sniffed, declared string) bool {
+	if sniffed == declared || sniffed == "application/octet-stream" || declared == "application/octet-stream" {
+		return true
+	}
+	switch sniffed {
+	case "text/plain", "text/xml":
+		return textLike(declared)
+	case "application/zip":
+		// Office documents, jars, epubs, ... are zip containers
+		return strings.Contains(declared, "zip") || strings.Contains(declared, "openxmlformats") ||
+			strings.Contains(declared, "opendocument") || declared == "application/java-archive" ||
+			declared == "application/epub+zip"
+	}
+
+	// Media formats shared between families (audio/mp4 vs video/mp4, ...)
+	sTop, sSub, _ := strings.Cut(sniffed, "/")
+	dTop, dSub, _ := strings.Cut(declared, "/")
+	return sSub == dSub && (sTop == "audio" || sTop == "video") && (dTop == "audio" || dTop == "video")
+}
+
+// resolveMIME sniffs the real content type from the first bytes of a file,
+// checks it against the type declared by the client and implied by the
+// filename extension, and returns the type to store: the sniffed one
+// unless a claim refines it (see mimeRefinements). Malformed or overlong
+// claims are ignored, even under the off policy. Under the reject policy
+// a mismatch returns errMIMEMismatch.
+func (h *FileHandler) resolveMIME(head []byte, declared, filename string) (string, error) {
+	sniffed := baseMIME(http.DetectContentType(head))
+
+	var claims []string
+	if d := claimedMIME(declared); d != "" {
+		claims = append(claims, d)
+	}
+	if e := claimedMIME(mime.TypeByExtension(strings.ToLower(filepath.Ext(filename)))); e != "" {
+		claims = append(claims, e)
+	}
+
+	if h.MIMEPolicy == MIMEPolicyOff {
+		if len(claims) > 0 {
+			return claims[0], nil
+		}
+		return sniffed, nil
+	}
+
+	result := sniffed
+	for _, c := range claims {
+		if !compatibleMIME(sniffed, c) {
+			if h.MIMEPolicy == MIMEPolicyReject {
+				return "", errMIMEMismatch
+			}
+			log.Printf("⚠️ MIME mismatch for %q: declared %s, content is %s", filename, c, sniffed)
+			return sniffed, nil
+		}
+		if result == sniffed && refinesMIME(sniffed, c) {
+			result = c
+		}
+	}
+	return result, nil
+}
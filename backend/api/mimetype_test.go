@@ -0,0 +1,36 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveMIME(t *testing.T) {
+	text := []byte("just some words\n")
+	for _, c := range []struct {
+		policy, declared, filename, want string
+	}{
+		{MIMEPolicyWarn, "application/json", "data.json", "application/json"},
+		{MIMEPolicyWarn, "text/csv; charset=utf-8", "report.csv", "text/csv"},
+		// Compatible with text, but not a refinement anyone should get
+		{MIMEPolicyWarn, "text/html", "page.txt", "text/plain"},
+		{MIMEPolicyWarn, "image/svg+xml", "", "text/plain"},
+		// Mismatches keep the sniffed type
+		{MIMEPolicyWarn, "image/png", "", "text/plain"},
+		// Malformed and overlong claims are ignored, even with the policy off
+		{MIMEPolicyOff, "text/plain/../html", "", "text/plain"},
+		{MIMEPolicyOff, "application/" + strings.Repeat("x", mimeMaxLen), "", "text/plain"},
+		{MIMEPolicyOff, "Application/JSON; charset=utf-8", "", "application/json"},
+	} {
+		h := &FileHandler{MIMEPolicy: c.policy}
+		got, err := h.resolveMIME(text, c.declared, c.filename)
+		if err != nil || got != c.want {
+			t.Errorf("%s %q %q: got %q, %v; want %q", c.policy, c.declared, c.filename, got, err, c.want)
+		}
+	}
+
+	h := &FileHandler{MIMEPolicy: MIMEPolicyReject}
+	if _, err := h.resolveMIME(text, "image/png", ""); err != errMIMEMismatch {
+		t.Fatalf("reject policy: got %v", err)
+	}
+}
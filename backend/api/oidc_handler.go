@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// OIDCHandler logs users in through an OpenID Connect provider using the
+// authorization code flow with PKCE, then hands out the vault's own
+// tokens exactly like /login. Accounts are matched by the provider's
+// subject, linked to an existing account by verified email, or created.
+type OIDCHandler struct {
+	DB       *pgxpool.Pool
+	Provider *OIDCProvider
+	Users    *UserHandler
+	Audit    *Auditor
+}
+
+// oidcLoginTTL bounds the time between /oidc/login and the callback
+const oidcLoginTTL = 10 * time.Minute
+
+var (
+	errOIDCNoEmail    = errors.New("identity provider sent no email")
+	errOIDCUnverified = errors.New("email not verified by identity provider")
+)
+
+// GET /oidc/login → redirect to the provider. Clients asking for JSON
+// (Accept: application/json) get {"auth_url"} to navigate to themselves.
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	state, err1 := utils.RandomToken(32)
+	nonce, err2 := utils.RandomToken(32)
+	verifier, err3 := utils.RandomToken(32)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		http.Error(w, "❌ Could not start login", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := h.Provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Println("⚠️ OIDC:", err)
+		http.Error(w, "❌ Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// Abandoned attempts are cleared out as new ones come in
+	if _, err := h.DB.Exec(r.Context(), `DELETE FROM oidc_logins WHERE expires_at < NOW()`); err != nil {
+		log.Println("⚠️ purging OIDC logins failed:", err)
+	}
+	_, err = h.DB.Exec(r.Context(),
+		`INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expires_at)
+		 VALUES ($1, $2, $3, $4)`,
+		utils.HashToken(state), nonce, verifier, time.Now().Add(oidcLoginTTL))
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"auth_url": authURL})
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// GET /oidc/callback?code&state → finish login; answers like /login.
+// Also takes POST {"code", "state"} for SPAs whose redirect URL is a
+// frontend route.
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+			return
+		}
+	} else {
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			http.Error(w, "❌ Login failed at identity provider: "+e, http.StatusUnauthorized)
+			return
+		}
+		req.Code, req.State = q.Get("code"), q.Get("state")
+	}
+	if req.Code == "" || req.State == "" {
+		http.Error(w, "❌ code and state are required", http.StatusBadRequest)
+		return
+	}
+
+	// Each state works once
+	var nonce, verifier string
+	err := h.DB.QueryRow(r.Context(),
+		`DELETE FROM oidc_logins WHERE state_hash=$1 AND expires_at > NOW()
+		 RETURNING nonce, code_verifier`, utils.HashToken(req.State),
+	).Scan(&nonce, &verifier)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ Unknown or expired login state", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	claims, err := h.Provider.Exchange(r.Context(), req.Code, verifier, nonce)
+	if err != nil {
+		log.Println("⚠️ OIDC:", err)
+		h.Audit.Log(r, AuditEvent{Action: AuditLoginFailed, TargetType: "user",
+			Details: map[string]interface{}{"method": "oidc", "reason": err.Error()}})
+		http.Error(w, "❌ Identity provider login failed", http.StatusUnauthorized)
+		return
+	}
+
+	var id int
+	var email, role string
+	var created, disabled, mfa bool
+	err = pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		var err error
+		if id, created, err = h.account(r.Context(), tx, claims); err != nil {
+			return err
+		}
+		return tx.QueryRow(r.Context(),
+			`SELECT email, COALESCE(role, 'user'), disabled_at IS NOT NULL, mfa_enabled_at IS NOT NULL
+			 FROM users WHERE id=$1`, id,
+		).Scan(&email, &role, &disabled, &mfa)
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, errOIDCNoEmail):
+		http.Error(w, "❌ The identity provider did not share an email address", http.StatusBadRequest)
+		return
+	case errors.Is(err, errOIDCUnverified):
+		http.Error(w, "❌ An account with this email exists; the identity provider must verify the email to link it", http.StatusConflict)
+		return
+	default:
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if created {
+		if claims.EmailVerified {
+			if err := claimInvites(r.Context(), h.DB, id, email); err != nil {
+				log.Printf("⚠️ claiming share invites for user %d failed: %v", id, err)
+			}
+		}
+		h.Audit.Log(r, AuditEvent{Action: AuditRegister, ActorID: &id, TargetType: "user", TargetID: auditTarget(id),
+			Details: map[string]interface{}{"method": "oidc", "email": email, "issuer": h.Provider.Issuer}})
+	}
+	if disabled {
+		h.Audit.Log(r, AuditEvent{Action: AuditLoginFailed, ActorID: &id, TargetType: "user", TargetID: auditTarget(id),
+			Details: map[string]interface{}{"method": "oidc", "reason": "account disabled"}})
+		http.Error(w, "❌ Account disabled", http.StatusForbidden)
+		return
+	}
+
+	h.Users.finishLogin(w, r, id, email, role, mfa, map[string]interface{}{"method": "oidc"})
+}
+
+// account finds the user for an identity, linking or creating one as
+// needed; created reports a new account
+func (h *OIDCHandler) account(ctx context.Context, tx pgx.Tx, c *OIDCClaims) (id int, created bool, err error) {
+	issuer := h.Provider.Issuer
+
+	err = tx.QueryRow(ctx,
+		`UPDATE user_identities SET last_login_at = NOW()
+		 WHERE issuer=$1 AND subject=$2 RETURNING user_id`, issuer, c.Subject,
+	).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	} else if err != pgx.ErrNoRows {
+		return 0, false, err
+	}
+
+	if c.Email == "" {
+		return 0, false, errOIDCNoEmail
+	}
+	err = tx.QueryRow(ctx, `SELECT id FROM users WHERE lower(email)=lower($1)`, c.Email).Scan(&id)
+	switch {
+	case err == nil:
+		// Only an address the provider vouches for may take over an account
+		if !c.EmailVerified {
+			return 0, false, errOIDCUnverified
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id=$1`, id); err != nil {
+			return 0, false, err
+		}
+	case err == pgx.ErrNoRows:
+		if id, err = createOIDCUser(ctx, tx, c); err != nil {
+			return 0, false, err
+		}
+		created = true
+	default:
+		return 0, false, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at)
+		 VALUES ($1, $2, $3, $4, NOW())`, id, issuer, c.Subject, c.Email)
+	return id, created, err
+}
+
+// createOIDCUser provisions an account as Register does. It has no
+// usable password: "!" never matches a bcrypt hash.
+func createOIDCUser(ctx context.Context, tx pgx.Tx, c *OIDCClaims) (int, error) {
+	base := oidcUsername(c)
+	var id int
+	for i := 1; ; i++ {
+		username := base
+		if i > 1 {
+			username = fmt.Sprintf("%s%d", base, i)
+		}
+		var taken bool
+		if err := tx.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM users WHERE username=$1)`, username).Scan(&taken); err != nil {
+			return 0, err
+		}
+		if taken {
+			continue
+		}
+		err := tx.QueryRow(ctx,
+			`INSERT INTO users (username, email, password_hash, email_verified_at)
+			 VALUES ($1, $2, '!', CASE WHEN $3 THEN NOW() END) RETURNING id`,
+			username, c.Email, c.EmailVerified,
+		).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		break
+	}
+
+	_, err := tx.Exec(ctx,
+		`INSERT INTO user_storage (user_id, used_bytes, quota_bytes)
+		 VALUES ($1, 0, 104857600)`, id)
+	return id, err
+}
+
+// oidcUsername derives a username from preferred_username or the email's
+// local part, keeping letters, digits, '.', '_' and '-'
+func oidcUsername(c *OIDCClaims) string {
+	name := c.Username
+	if name == "" {
+		name, _, _ = strings.Cut(c.Email, "@")
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		}
+		return -1
+	}, name)
+	// Same rules as Register, leaving room for a numeric suffix
+	name = strings.TrimLeft(name, "._-")
+	if len(name) > usernameMax-3 {
+		name = name[:usernameMax-3]
+	}
+	if len(name) < usernameMin {
+		name = "user"
+	}
+	return name
+}
@@ -0,0 +1,470 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const testClientID = "vault-client"
+
+// mockIdP is a local OpenID provider: discovery, a JWKS that can be
+// rotated, and a token endpoint that checks PKCE before handing out
+// whatever ID token the test queued for the code.
+type mockIdP struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu         sync.Mutex
+	keys       map[string]interface{} // kid → private key, as published
+	jwksHits   int
+	codes      map[string]mockGrant
+	issuerSeen string // issuer advertised in discovery, if not the URL
+}
+
+type mockGrant struct {
+	challenge string
+	idToken   string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	m := &mockIdP{t: t, keys: map[string]interface{}{}, codes: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := m.srv.URL
+		if m.issuerSeen != "" {
+			issuer = m.issuerSeen
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.jwksHits++
+		var keys []map[string]string
+		for kid, k := range m.keys {
+			keys = append(keys, publicJWK(kid, k))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		r.ParseForm()
+		grant, ok := m.codes[r.PostForm.Get("code")]
+		delete(m.codes, r.PostForm.Get("code"))
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		switch {
+		case !ok:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		case base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		case r.PostForm.Get("grant_type") != "authorization_code":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unsupported_grant_type"})
+		default:
+			json.NewEncoder(w).Encode(map[string]string{"id_token": grant.idToken, "token_type": "Bearer"})
+		}
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func (m *mockIdP) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Issuer:      m.srv.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://vault.test/oidc/callback",
+		Client:      m.srv.Client(),
+	}
+}
+
+// publish replaces the JWKS with keys
+func (m *mockIdP) publish(keys map[string]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = keys
+}
+
+// authorize plays the browser leg: it reads the challenge off the auth
+// URL and issues a code that redeems for idToken
+func (m *mockIdP) authorize(authURL, code, idToken string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[code] = mockGrant{challenge: u.Query().Get("code_challenge"), idToken: idToken}
+}
+
+func b64int(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func publicJWK(kid string, key interface{}) map[string]string {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig",
+			"n": b64int(k.N.Bytes()), "e": b64int(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PrivateKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return map[string]string{"kty": "EC", "kid": kid, "use": "sig", "crv": k.Curve.Params().Name,
+			"x": b64int(k.X.FillBytes(make([]byte, size))), "y": b64int(k.Y.FillBytes(make([]byte, size)))}
+	}
+	panic(fmt.Sprintf("unsupported key %T", key))
+}
+
+var (
+	testKeysOnce sync.Once
+	testRSA1     *rsa.PrivateKey
+	testRSA2     *rsa.PrivateKey
+	testEC       *ecdsa.PrivateKey
+)
+
+func testKeys(t *testing.T) (*rsa.PrivateKey, *rsa.PrivateKey, *ecdsa.PrivateKey) {
+	testKeysOnce.Do(func() {
+		var err1, err2, err3 error
+		testRSA1, err1 = rsa.GenerateKey(rand.Reader, 2048)
+		testRSA2, err2 = rsa.GenerateKey(rand.Reader, 2048)
+		testEC, err3 = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err := errors.Join(err1, err2, err3); err != nil {
+			panic(err)
+		}
+	})
+	return testRSA1, testRSA2, testEC
+}
+
+// idClaims are valid claims for m; tests break one thing at a time
+func (m *mockIdP) idClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            m.srv.URL,
+		"aud":            testClientID,
+		"sub":            "subject-1",
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          "Ada@Example.com",
+		"email_verified": true,
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	raw, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestOIDCDiscoveryAndExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	rsa1, _, _ := testKeys(t)
+	idp.publish(map[string]interface{}{"k1": rsa1})
+	p := idp.provider()
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("client_id") != testClientID || q.Get("state") != "state-1" ||
+		q.Get("nonce") != "nonce-1" || q.Get("code_challenge_method") != "S256" ||
+		q.Get("response_type") != "code" || !strings.Contains(q.Get("scope"), "openid") {
+		t.Fatalf("auth URL %s", authURL)
+	}
+
+	idp.authorize(authURL, "code-1", sign(t, jwt.SigningMethodRS256, "k1", rsa1, idp.idClaims("nonce-1")))
+	claims, err := p.Exchange(ctx, "code-1", "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "subject-1" || claims.Email != "Ada@Example.com" || !claims.EmailVerified {
+		t.Fatalf("claims %+v", claims)
+	}
+
+	// Codes work once, and only with the matching verifier
+	if _, err := p.Exchange(ctx, "code-1", "verifier-1", "nonce-1"); err == nil {
+		t.Error("code redeemed twice")
+	}
+	idp.authorize(authURL, "code-2", sign(t, jwt.SigningMethodRS256, "k1", rsa1, idp.idClaims("nonce-1")))
+	if _, err := p.Exchange(ctx, "code-2", "wrong-verifier", "nonce-1"); err == nil || !strings.Contains(err.Error(), "PKCE") {
+		t.Errorf("wrong verifier: %v", err)
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.issuerSeen = "https://evil.example"
+	if _, err := idp.provider().AuthCodeURL(context.Background(), "s", "n", "v"); err == nil ||
+		!strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("issuer mismatch: %v", err)
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	idp := newMockIdP(t)
+	rsa1, rsa2, ec := testKeys(t)
+	idp.publish(map[string]interface{}{"k1": rsa1})
+	p := idp.provider()
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, sign(t, jwt.SigningMethodRS256, "k1", rsa1, idp.idClaims("n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider rotates to k2 (and adds an EC key); k1 is retired
+	idp.publish(map[string]interface{}{"k2": rsa2, "k3": ec})
+	rotated := sign(t, jwt.SigningMethodRS256, "k2", rsa2, idp.idClaims("n"))
+
+	// A fresh key set isn't refetched for every unknown kid...
+	hits := idp.jwksHits
+	if _, err := p.VerifyIDToken(ctx, rotated, "n"); err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("unknown kid within refresh interval: %v", err)
+	}
+	if idp.jwksHits != hits {
+		t.Fatal("key set refetched within the refresh interval")
+	}
+
+	// ...but once it's old enough, the new kid triggers a refetch
+	p.keysAt = p.keysAt.Add(-oidcKeysRefresh)
+	if _, err := p.VerifyIDToken(ctx, rotated, "n"); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if idp.jwksHits != hits+1 {
+		t.Fatalf("jwks fetched %d times, want once", idp.jwksHits-hits)
+	}
+	if _, err := p.VerifyIDToken(ctx, sign(t, jwt.SigningMethodES256, "k3", ec, idp.idClaims("n")), "n"); err != nil {
+		t.Fatalf("EC key: %v", err)
+	}
+
+	// Tokens signed with the retired key no longer verify
+	if _, err := p.VerifyIDToken(ctx, sign(t, jwt.SigningMethodRS256, "k1", rsa1, idp.idClaims("n")), "n"); err == nil {
+		t.Fatal("retired key accepted")
+	}
+}
+
+func TestOIDCVerifyIDTokenRejects(t *testing.T) {
+	idp := newMockIdP(t)
+	rsa1, rsa2, ec := testKeys(t)
+	idp.publish(map[string]interface{}{"k1": rsa1, "k3": ec})
+	p := idp.provider()
+	ctx := context.Background()
+
+	with := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		c := idp.idClaims("nonce-1")
+		change(c)
+		return c
+	}
+	rs := func(c jwt.MapClaims) string { return sign(t, jwt.SigningMethodRS256, "k1", rsa1, c) }
+
+	hmacKey := rsa1.PublicKey.N.Bytes() // public material an alg-confusion attack would use
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, idp.idClaims("nonce-1")).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		nonce string
+		want  string
+	}{
+		{"valid", rs(idp.idClaims("nonce-1")), "nonce-1", ""},
+		{"nonce mismatch", rs(idp.idClaims("nonce-1")), "nonce-2", "nonce"},
+		{"nonce missing", rs(with(func(c jwt.MapClaims) { delete(c, "nonce") })), "nonce-1", "nonce"},
+		{"wrong issuer", rs(with(func(c jwt.MapClaims) { c["iss"] = "https://other.example" })), "nonce-1", "issuer"},
+		{"wrong audience", rs(with(func(c jwt.MapClaims) { c["aud"] = "someone-else" })), "nonce-1", "audience"},
+		{"audience list", rs(with(func(c jwt.MapClaims) {
+			c["aud"] = []string{"someone-else", testClientID}
+			c["azp"] = testClientID
+		})), "nonce-1", ""},
+		{"wrong azp", rs(with(func(c jwt.MapClaims) {
+			c["aud"] = []string{"someone-else", testClientID}
+			c["azp"] = "someone-else"
+		})), "nonce-1", "authorized party"},
+		{"expired", rs(with(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() })), "nonce-1", "expired"},
+		{"no expiry", rs(with(func(c jwt.MapClaims) { delete(c, "exp") })), "nonce-1", "expiry"},
+		{"no subject", rs(with(func(c jwt.MapClaims) { delete(c, "sub") })), "nonce-1", "subject"},
+		{"alg none", none, "nonce-1", "signing method"},
+		{"alg HS256", sign(t, jwt.SigningMethodHS256, "k1", hmacKey, idp.idClaims("nonce-1")), "nonce-1", "signing method"},
+		{"alg RS256 with EC key", sign(t, jwt.SigningMethodRS256, "k3", rsa1, idp.idClaims("nonce-1")), "nonce-1", "id token"},
+		{"unpublished key", sign(t, jwt.SigningMethodRS256, "k1", rsa2, idp.idClaims("nonce-1")), "nonce-1", "verification error"},
+		{"tampered", rs(idp.idClaims("nonce-1"))[:40] + "x" + rs(idp.idClaims("nonce-1"))[41:], "nonce-1", "id token"},
+	} {
+		_, err := p.VerifyIDToken(ctx, tc.token, tc.nonce)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		case tc.want != "" && err == nil:
+			t.Errorf("%s: accepted", tc.name)
+		case tc.want != "" && !strings.Contains(err.Error(), tc.want):
+			t.Errorf("%s: error %q, want it to mention %q", tc.name, err, tc.want)
+		}
+	}
+
+	// email_verified sent as a string still counts
+	c, err := p.VerifyIDToken(ctx, rs(with(func(c jwt.MapClaims) { c["email_verified"] = "true" })), "nonce-1")
+	if err != nil || !c.EmailVerified {
+		t.Errorf("string email_verified: %+v, %v", c, err)
+	}
+	c, err = p.VerifyIDToken(ctx, rs(with(func(c jwt.MapClaims) { c["email_verified"] = "false" })), "nonce-1")
+	if err != nil || c.EmailVerified {
+		t.Errorf("string email_verified=false: %+v, %v", c, err)
+	}
+}
+
+// oidcTx stands in for the database in OIDCHandler.account: one existing
+// password account plus whatever identities and users the test adds
+type oidcTx struct {
+	pgx.Tx // unused methods panic
+
+	users      map[int]string // id → email
+	verified   map[int]bool
+	identities map[string]int // issuer + " " + subject → user id
+	storage    map[int]bool
+}
+
+type oidcRow struct {
+	vals []interface{}
+	err  error
+}
+
+func (r oidcRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	for i, v := range r.vals {
+		switch d := dest[i].(type) {
+		case *int:
+			*d = v.(int)
+		case *bool:
+			*d = v.(bool)
+		}
+	}
+	return nil
+}
+
+func (tx *oidcTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	switch {
+	case strings.Contains(sql, "UPDATE user_identities"):
+		if id, ok := tx.identities[args[0].(string)+" "+args[1].(string)]; ok {
+			return oidcRow{vals: []interface{}{id}}
+		}
+		return oidcRow{err: pgx.ErrNoRows}
+	case strings.Contains(sql, "SELECT id FROM users WHERE lower(email)=lower($1)"):
+		for id, email := range tx.users {
+			if strings.EqualFold(email, args[0].(string)) {
+				return oidcRow{vals: []interface{}{id}}
+			}
+		}
+		return oidcRow{err: pgx.ErrNoRows}
+	case strings.Contains(sql, "SELECT EXISTS (SELECT 1 FROM users WHERE username=$1)"):
+		return oidcRow{vals: []interface{}{false}}
+	case strings.Contains(sql, "INSERT INTO users"):
+		id := len(tx.users) + 1
+		tx.users[id] = args[1].(string)
+		tx.verified[id] = args[2].(bool)
+		return oidcRow{vals: []interface{}{id}}
+	}
+	return oidcRow{err: fmt.Errorf("oidcTx: unexpected query %q", sql)}
+}
+
+func (tx *oidcTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	switch {
+	case strings.Contains(sql, "UPDATE users SET email_verified_at"):
+		tx.verified[args[0].(int)] = true
+	case strings.Contains(sql, "INSERT INTO user_storage"):
+		tx.storage[args[0].(int)] = true
+	case strings.Contains(sql, "INSERT INTO user_identities"):
+		tx.identities[args[1].(string)+" "+args[2].(string)] = args[0].(int)
+	default:
+		return pgconn.CommandTag{}, fmt.Errorf("oidcTx: unexpected statement %q", sql)
+	}
+	return pgconn.NewCommandTag("OK 1"), nil
+}
+
+func newOIDCTx() *oidcTx {
+	return &oidcTx{
+		users:      map[int]string{1: "ada@example.com"},
+		verified:   map[int]bool{},
+		identities: map[string]int{},
+		storage:    map[int]bool{},
+	}
+}
+
+func TestOIDCAccountLinking(t *testing.T) {
+	h := &OIDCHandler{Provider: &OIDCProvider{Issuer: "https://idp.example"}}
+	ctx := context.Background()
+
+	// An unverified email can't take over the existing account
+	tx := newOIDCTx()
+	_, _, err := h.account(ctx, tx, &OIDCClaims{Subject: "s1", Email: "ADA@example.com", EmailVerified: false})
+	if !errors.Is(err, errOIDCUnverified) {
+		t.Fatalf("unverified link: %v", err)
+	}
+	if len(tx.identities) != 0 || tx.verified[1] {
+		t.Fatalf("unverified email linked: %+v", tx)
+	}
+
+	// A verified one links (case-insensitively) and marks the address verified
+	id, created, err := h.account(ctx, tx, &OIDCClaims{Subject: "s1", Email: "ADA@example.com", EmailVerified: true})
+	if err != nil || id != 1 || created {
+		t.Fatalf("verified link: id=%d created=%v err=%v", id, created, err)
+	}
+	if tx.identities["https://idp.example s1"] != 1 || !tx.verified[1] {
+		t.Fatalf("link not recorded: %+v", tx)
+	}
+
+	// From then on the subject alone finds the account, whatever the email says
+	id, created, err = h.account(ctx, tx, &OIDCClaims{Subject: "s1", Email: "changed@example.com"})
+	if err != nil || id != 1 || created {
+		t.Fatalf("known subject: id=%d created=%v err=%v", id, created, err)
+	}
+
+	// Unknown emails get a new account, verified only if the provider says so
+	id, created, err = h.account(ctx, tx, &OIDCClaims{Subject: "s2", Email: "bob@example.com", Username: "bob"})
+	if err != nil || !created || id == 1 {
+		t.Fatalf("new account: id=%d created=%v err=%v", id, created, err)
+	}
+	if tx.verified[id] || !tx.storage[id] || tx.identities["https://idp.example s2"] != id {
+		t.Fatalf("new account state: %+v", tx)
+	}
+
+	// No email at all is refused
+	if _, _, err := h.account(ctx, tx, &OIDCClaims{Subject: "s3"}); !errors.Is(err, errOIDCNoEmail) {
+		t.Fatalf("no email: %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Share permissions, weakest first. Each one includes everything below it:
+// comment can read, write can also replace the content and rename, and
+// reshare can additionally share the file on (up to its own permission).
+const (
+	PermRead    = "read"
+	PermComment = "comment"
+	PermWrite   = "write"
+	PermReshare = "reshare"
+
+	// permOwner is never stored; it's what filePermission reports for the owner
+	permOwner = "owner"
+)
+
+var permLevels = map[string]int{
+	PermRead:    1,
+	PermComment: 2,
+	PermWrite:   3,
+	PermReshare: 4,
+	permOwner:   5,
+}
+
+// sharePermissions lists the values accepted as share_type
+var sharePermissions = []string{PermRead, PermComment, PermWrite, PermReshare}
+
+// validPermission reports whether p may be stored as a share_type
+func validPermission(p string) bool {
+	return p != permOwner && permLevels[p] > 0
+}
+
+// allows reports whether holding perm grants need
+func allows(perm, need string) bool {
+	return permLevels[perm] > 0 && permLevels[perm] >= permLevels[need]
+}
+
+// grantedPermissions expands perm into every share permission it includes
+func grantedPermissions(perm string) []string {
+	granted := []string{}
+	for _, p := range sharePermissions {
+		if allows(perm, p) {
+			granted = append(granted, p)
+		}
+	}
+	return granted
+}
+
+// filePermission returns the owner of a (non-trashed) file and the
+// strongest permission userID holds on it: permOwner, a share permission,
+// or "" for no access.
+func (h *FileHandler) filePermission(ctx context.Context, fileID, userID int) (ownerID int, perm string, err error) {
+	err = h.DB.QueryRow(ctx,
+		`SELECT user_id FROM files WHERE id=$1 AND NOT is_deleted`, fileID).Scan(&ownerID)
+	if err == pgx.ErrNoRows {
+		return 0, "", errFileNotFound
+	} else if err != nil {
+		return 0, "", err
+	}
+	if ownerID == userID {
+		return ownerID, permOwner, nil
+	}
+
+	grants, err := h.fileGrants(ctx, fileID)
+	if err != nil {
+		return 0, "", err
+	}
+	return ownerID, resolvePermission(ownerID, userID, grants), nil
+}
+
+// shareGrant is a share of a file as it reaches one user: group shares
+// are expanded to every current member
+type shareGrant struct {
+	From, To int
+	Perm     string
+}
+
+// fileGrants loads every share of fileID. Group shares are resolved
+// through current membership, so adding or removing a member changes
+// their access straight away.
+func (h *FileHandler) fileGrants(ctx context.Context, fileID int) ([]shareGrant, error) {
+	rows, err := h.DB.Query(ctx,
+		`SELECT s.shared_by, COALESCE(s.target_user, gm.user_id), s.share_type
+		 FROM shares s
+		 LEFT JOIN group_members gm ON gm.group_id = s.target_group
+		 WHERE s.file_id=$1 AND COALESCE(s.target_user, gm.user_id) IS NOT NULL`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[shareGrant])
+}
+
+// validGrantors is the set of users whose shares of the file count: the
+// owner, and anyone holding reshare through a share that itself counts.
+// A share made by someone who has since been downgraded or cut off lapses
+// with them.
+func validGrantors(ownerID int, grants []shareGrant) map[int]bool {
+	valid := map[int]bool{ownerID: true}
+	for changed := true; changed; {
+		changed = false
+		for _, g := range grants {
+			if g.Perm == PermReshare && valid[g.From] && !valid[g.To] {
+				valid[g.To] = true
+				changed = true
+			}
+		}
+	}
+	return valid
+}
+
+// resolvePermission is the strongest permission userID holds through
+// grants whose grantor still counts, or "" for none
+func resolvePermission(ownerID, userID int, grants []shareGrant) string {
+	if userID == ownerID {
+		return permOwner
+	}
+	valid := validGrantors(ownerID, grants)
+	perm := ""
+	for _, g := range grants {
+		if g.To == userID && valid[g.From] && validPermission(g.Perm) && permLevels[g.Perm] > permLevels[perm] {
+			perm = g.Perm
+		}
+	}
+	return perm
+}
+
+// requirePermission checks that userID holds need on fileID, writing a
+// 404/403 response if not
+func (h *FileHandler) requirePermission(w http.ResponseWriter, r *http.Request, fileID, userID int, need string) (ownerID int, perm string, ok bool) {
+	ownerID, perm, err := h.filePermission(r.Context(), fileID, userID)
+	if err == errFileNotFound {
+		http.Error(w, "❌ File not found", http.StatusNotFound)
+		return 0, "", false
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return 0, "", false
+	}
+	if perm == "" {
+		http.Error(w, "❌ Forbidden", http.StatusForbidden)
+		return 0, "", false
+	}
+	if !allows(perm, need) {
+		http.Error(w, "❌ Forbidden: requires "+need+" permission", http.StatusForbidden)
+		return 0, "", false
+	}
+	return ownerID, perm, true
+}
+
+// invalidPermission is the error text for a bad share_type
+func invalidPermission() string {
+	return "❌ Invalid share_type (use " + strings.Join(sharePermissions, ", ") + ")"
+}
+
+// requireVerified enforces RequireVerifiedEmail, responding 403 for users
+// who haven't confirmed their address yet
+func (h *FileHandler) requireVerified(w http.ResponseWriter, r *http.Request, userID int) bool {
+	if !h.RequireVerifiedEmail {
+		return true
+	}
+	var verified bool
+	err := h.DB.QueryRow(r.Context(),
+		`SELECT email_verified_at IS NOT NULL FROM users WHERE id=$1`, userID).Scan(&verified)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !verified {
+		http.Error(w, "❌ Verify your email address before sharing", http.StatusForbidden)
+		return false
+	}
+	return true
+}
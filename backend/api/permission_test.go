@@ -0,0 +1,61 @@
+package api
+
+import "testing"
+
+func TestResolvePermission(t *testing.T) {
+	const owner, alice, bob, carol = 1, 2, 3, 4
+	grants := []shareGrant{
+		{From: owner, To: alice, Perm: PermReshare},
+		{From: alice, To: bob, Perm: PermWrite},
+		{From: bob, To: carol, Perm: PermRead}, // bob never held reshare
+	}
+	for _, c := range []struct {
+		user int
+		want string
+	}{
+		{owner, permOwner},
+		{alice, PermReshare},
+		{bob, PermWrite},
+		{carol, ""},
+	} {
+		if got := resolvePermission(owner, c.user, grants); got != c.want {
+			t.Errorf("user %d: got %q, want %q", c.user, got, c.want)
+		}
+	}
+}
+
+func TestResolvePermissionGrantorLostReshare(t *testing.T) {
+	const owner, alice, bob = 1, 2, 3
+	// alice reshared to bob, then the owner cut her down to read
+	grants := []shareGrant{
+		{From: owner, To: alice, Perm: PermRead},
+		{From: alice, To: bob, Perm: PermWrite},
+	}
+	if got := resolvePermission(owner, bob, grants); got != "" {
+		t.Fatalf("bob kept %q after alice lost reshare", got)
+	}
+
+	// A reshare cycle with no path back to the owner grants nothing
+	grants = append(grants, shareGrant{From: bob, To: alice, Perm: PermReshare})
+	if got := resolvePermission(owner, bob, grants); got != "" {
+		t.Fatalf("cycle granted bob %q", got)
+	}
+	if got := resolvePermission(owner, alice, grants); got != PermRead {
+		t.Fatalf("cycle granted alice %q", got)
+	}
+}
+
+func TestResolvePermissionGrantorRevoked(t *testing.T) {
+	const owner, alice, bob = 1, 2, 3
+	grants := []shareGrant{
+		{From: owner, To: alice, Perm: PermReshare},
+		{From: alice, To: bob, Perm: PermReshare},
+	}
+	if got := resolvePermission(owner, bob, grants); got != PermReshare {
+		t.Fatalf("bob got %q before the revoke", got)
+	}
+	// Revoking alice's share leaves only the one she made
+	if got := resolvePermission(owner, bob, grants[1:]); got != "" {
+		t.Fatalf("bob kept %q after alice was revoked", got)
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+)
+
+// RateLimit is a token bucket: up to Burst requests at once, refilled at
+// Burst per Per. The zero value means no limit.
+type RateLimit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// ParseRateLimit reads "<requests>/<duration>", e.g. "20/1m". "" and
+// "off" mean no limit.
+func ParseRateLimit(s string) (RateLimit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return RateLimit{}, nil
+	}
+	n, d, ok := strings.Cut(s, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q: want <requests>/<duration>", s)
+	}
+	burst, err := strconv.Atoi(n)
+	if err != nil || burst <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: bad request count", s)
+	}
+	per, err := time.ParseDuration(d)
+	if err != nil || per <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: bad duration", s)
+	}
+	return RateLimit{Burst: burst, Per: per}, nil
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// Limiter keeps one token bucket per key (client IP, account, ...). A nil
+// Limiter allows everything.
+type Limiter struct {
+	limit RateLimit
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// NewLimiter returns a Limiter for l, or nil if l is no limit
+func NewLimiter(l RateLimit) *Limiter {
+	if l.Burst <= 0 {
+		return nil
+	}
+	return &Limiter{limit: l, now: time.Now, buckets: map[string]*bucket{}}
+}
+
+// Allow takes a token from key's bucket. When it is empty, it reports how
+// long until the next token.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	rate := float64(l.limit.Burst) / l.limit.Per.Seconds() // tokens per second
+	l.sweep(now, rate)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.at).Seconds()*rate)
+	b.at = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep forgets buckets that have refilled completely, at most once per
+// Per, so idle clients don't pile up
+func (l *Limiter) sweep(now time.Time, rate float64) {
+	if now.Sub(l.swept) < l.limit.Per {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.at).Seconds()*rate >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// tooManyRequests answers 429 with Retry-After in whole seconds
+func tooManyRequests(w http.ResponseWriter, retry time.Duration, msg string) {
+	secs := int(math.Ceil(retry.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
+
+// RouteLimits rate-limits a group of routes (auth, upload, download)
+// per client IP and, once Auth has identified the user, per account
+type RouteLimits struct {
+	IP      *Limiter
+	Account *Limiter
+	Proxies TrustedProxies
+}
+
+// NewRouteLimits applies l separately per IP and per account
+func NewRouteLimits(l RateLimit, proxies TrustedProxies) *RouteLimits {
+	return &RouteLimits{IP: NewLimiter(l), Account: NewLimiter(l), Proxies: proxies}
+}
+
+// Wrap limits next. Put it inside Auth so the account is known.
+func (rl *RouteLimits) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retry := rl.IP.Allow(rl.Proxies.ClientIP(r)); !ok {
+			tooManyRequests(w, retry, "❌ Too many requests")
+			return
+		}
+		if userID, ok := utils.GetUserID(r.Context()); ok {
+			if ok, retry := rl.Account.Allow(strconv.Itoa(userID)); !ok {
+				tooManyRequests(w, retry, "❌ Too many requests")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -36,7 +36,7 @@ func (h *ShareHandler) ShareFile(w http.ResponseWriter, r *http.Request) {
 	// Ensure file belongs to sharer
 	var ownerID int
 	err := h.DB.QueryRow(r.Context(),
-		`SELECT user_id FROM files WHERE id=$1`, req.FileID).Scan(&ownerID)
+		`SELECT user_id FROM files WHERE id=$1 AND NOT is_deleted`, req.FileID).Scan(&ownerID)
 	if err != nil || ownerID != userID {
 		http.Error(w, "❌ You don’t own this file", http.StatusForbidden)
 		return
@@ -68,10 +68,10 @@ func (h *ShareHandler) GetSharedFiles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rows, err := h.DB.Query(context.Background(),
-		`SELECT f.id, f.filename, f.filepath, f.file_hash, f.ref_count, f.uploaded_at, s.shared_by
+		`SELECT f.id, f.filename, COALESCE(f.filepath, ''), f.file_hash, `+ownRefCount+`, f.uploaded_at, s.shared_by
 		 FROM shares s
 		 JOIN files f ON s.file_id = f.id
-		 WHERE s.target_user=$1
+		 WHERE s.target_user=$1 AND NOT f.is_deleted
 		 ORDER BY s.shared_at DESC`, userID)
 	if err != nil {
 		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
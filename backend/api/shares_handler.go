@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// GET /files/{id}/shares → who (users and groups) a file is shared with. The owner sees every
+// share; users with reshare permission see the ones they granted.
+func (h *FileHandler) GetFileShares(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid file id", http.StatusBadRequest)
+		return
+	}
+	perm, err := h.managerPermission(r.Context(), fileID, userID)
+	if err == errFileNotFound {
+		http.Error(w, "❌ File not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !allows(perm, PermReshare) {
+		http.Error(w, "❌ Forbidden: requires "+PermReshare+" permission", http.StatusForbidden)
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT s.id, s.file_id, s.shared_by, s.target_user, COALESCE(u.username, ''), COALESCE(u.email, ''),
+		        s.target_group, COALESCE(g.name, ''), s.share_type, COALESCE(s.shared_at, s.created_at)
+		 FROM shares s
+		 LEFT JOIN users u ON u.id = s.target_user
+		 LEFT JOIN groups g ON g.id = s.target_group
+		 WHERE s.file_id=$1 AND ($2 OR s.shared_by=$3)
+		 ORDER BY s.shared_at DESC`,
+		fileID, perm == permOwner, userID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	shares, err := pgx.CollectRows(rows, pgx.RowToStructByPos[models.Share])
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shares)
+}
+
+// managedShare loads the share in the URL if userID may change it: the
+// file owner can manage every share, anyone else only the ones they made.
+// Returns the share and the caller's own permission on the file.
+func (h *FileHandler) managedShare(w http.ResponseWriter, r *http.Request, userID int) (models.Share, string, bool) {
+	var s models.Share
+	shareID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid share id", http.StatusBadRequest)
+		return s, "", false
+	}
+
+	err = h.DB.QueryRow(r.Context(),
+		`SELECT s.id, s.file_id, s.shared_by, s.target_user, COALESCE(u.username, ''), COALESCE(u.email, ''),
+		        s.target_group, COALESCE(g.name, ''), s.share_type, COALESCE(s.shared_at, s.created_at)
+		 FROM shares s
+		 LEFT JOIN users u ON u.id = s.target_user
+		 LEFT JOIN groups g ON g.id = s.target_group
+		 WHERE s.id=$1`, shareID,
+	).Scan(&s.ID, &s.FileID, &s.SharedBy, &s.TargetUser, &s.TargetName, &s.TargetEmail,
+		&s.TargetGroup, &s.GroupName, &s.ShareType, &s.SharedAt)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ Share not found", http.StatusNotFound)
+		return s, "", false
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return s, "", false
+	}
+
+	perm, err := h.managerPermission(r.Context(), s.FileID, userID)
+	if err != nil && err != errFileNotFound {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return s, "", false
+	}
+	if perm != permOwner && s.SharedBy != userID {
+		// Don't reveal shares to people who can't manage them
+		http.Error(w, "❌ Share not found", http.StatusNotFound)
+		return s, "", false
+	}
+	return s, perm, true
+}
+
+// managerPermission is userID's permission for managing the shares of
+// fileID. The owner keeps it while the file is in the trash, so its shares
+// can still be reviewed and revoked; anyone else needs live access.
+func (h *FileHandler) managerPermission(ctx context.Context, fileID, userID int) (string, error) {
+	var ownerID int
+	err := h.DB.QueryRow(ctx, `SELECT user_id FROM files WHERE id=$1`, fileID).Scan(&ownerID)
+	if err == pgx.ErrNoRows {
+		return "", errFileNotFound
+	} else if err != nil {
+		return "", err
+	}
+	if ownerID == userID {
+		return permOwner, nil
+	}
+	_, perm, err := h.filePermission(ctx, fileID, userID)
+	return perm, err
+}
+
+// PATCH /shares/{id} → change a share's permission ({"share_type"}).
+// Taking reshare away suspends the shares the recipient made with it.
+func (h *FileHandler) UpdateShare(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ShareType string `json:"share_type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+	if !validPermission(req.ShareType) {
+		http.Error(w, invalidPermission(), http.StatusBadRequest)
+		return
+	}
+
+	share, perm, ok := h.managedShare(w, r, userID)
+	if !ok {
+		return
+	}
+	// Someone who has since lost reshare can still revoke, but not upgrade
+	if !allows(perm, PermReshare) || !allows(perm, req.ShareType) {
+		http.Error(w, "❌ Cannot grant more than your own permission", http.StatusForbidden)
+		return
+	}
+
+	_, err := h.DB.Exec(r.Context(),
+		`UPDATE shares SET share_type=$1 WHERE id=$2`, req.ShareType, share.ID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.Audit.Log(r, AuditEvent{Action: AuditShareUpdate, ActorID: &userID, TargetType: "share", TargetID: auditTarget(share.ID),
+		Details: map[string]interface{}{"file_id": share.FileID, "from": share.ShareType, "to": req.ShareType}})
+	share.ShareType = req.ShareType
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(share)
+}
+
+// DELETE /shares/{id} → revoke a share; access checks read the shares
+// table on every request, so it takes effect immediately. Shares the
+// recipient made with reshare lapse with it (see validGrantors).
+func (h *FileHandler) RevokeShare(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	share, _, ok := h.managedShare(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if _, err := h.DB.Exec(r.Context(), `DELETE FROM shares WHERE id=$1`, share.ID); err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditShareRevoke, ActorID: &userID, TargetType: "share", TargetID: auditTarget(share.ID),
+		Details: map[string]interface{}{"file_id": share.FileID, "target_user": share.TargetUser, "target_group": share.TargetGroup}})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Share revoked"})
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+	"github.com/jackc/pgx/v5"
+)
+
+// Refresh tokens are opaque random strings, stored only as a hash. Each
+// one can be used once: /token/refresh swaps it for a new one in the same
+// family. Presenting a token that was already swapped means someone kept
+// a copy, so the whole family is revoked and its holder must log in again.
+
+var errRefreshInvalid = errors.New("invalid refresh token")
+
+// tokenResponse is what login and refresh return. "token" is the access
+// token, kept under its old name for existing clients.
+type tokenResponse struct {
+	Token        string `json:"token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// issueTokens creates an access token and a refresh token in family
+// (a new family when empty) inside tx
+func (h *UserHandler) issueTokens(ctx context.Context, tx pgx.Tx, r *http.Request, userID int, email, role, family string) (tokenResponse, error) {
+	access, _, err := utils.GenerateAccessToken(userID, email, role, h.Secret, h.AccessTTL)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	refresh, err := utils.RandomToken(32)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if family == "" {
+		if family, err = utils.RandomToken(16); err != nil {
+			return tokenResponse{}, err
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, user_agent, ip)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		userID, family, utils.HashToken(refresh), time.Now().Add(h.RefreshTTL),
+		r.UserAgent(), h.Proxies.ClientIP(r))
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return tokenResponse{
+		Token:        access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(h.AccessTTL.Seconds()),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// POST /token/refresh → swap a refresh token for new tokens
+// ({"refresh_token"})
+func (h *UserHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var resp tokenResponse
+	var reused bool
+	var userID int
+	var family string
+	err := pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		var tokenID int
+		var expires time.Time
+		var used, revoked, disabled bool
+		var email, role string
+		err := tx.QueryRow(r.Context(),
+			`SELECT t.id, t.user_id, t.family_id, t.expires_at, t.used_at IS NOT NULL, t.revoked_at IS NOT NULL,
+			        u.email, COALESCE(u.role, 'user'), u.disabled_at IS NOT NULL
+			 FROM refresh_tokens t JOIN users u ON u.id = t.user_id
+			 WHERE t.token_hash=$1
+			 FOR UPDATE OF t`, utils.HashToken(req.RefreshToken),
+		).Scan(&tokenID, &userID, &family, &expires, &used, &revoked, &email, &role, &disabled)
+		if err == pgx.ErrNoRows {
+			return errRefreshInvalid
+		} else if err != nil {
+			return err
+		}
+
+		if used && !revoked {
+			// Reuse of a rotated token: kill the family, commit, then refuse
+			reused = true
+			_, err := tx.Exec(r.Context(),
+				`UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id=$1 AND revoked_at IS NULL`, family)
+			return err
+		}
+		if used || revoked || disabled || time.Now().After(expires) {
+			return errRefreshInvalid
+		}
+
+		if _, err := tx.Exec(r.Context(),
+			`UPDATE refresh_tokens SET used_at = NOW() WHERE id=$1`, tokenID); err != nil {
+			return err
+		}
+		resp, err = h.issueTokens(r.Context(), tx, r, userID, email, role, family)
+		return err
+	})
+	if reused {
+		h.Audit.Log(r, AuditEvent{Action: AuditTokenReuse, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID),
+			Details: map[string]interface{}{"family": family}})
+		http.Error(w, "❌ Refresh token reused; all sessions from this login were revoked", http.StatusUnauthorized)
+		return
+	}
+	if err == errRefreshInvalid {
+		http.Error(w, "❌ Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// revokeAccessToken puts the request's own access token on the jti
+// denylist until it expires
+func (h *UserHandler) revokeAccessToken(ctx context.Context, r *http.Request, userID int) error {
+	claims, _ := bearerClaims(r, h.Secret)
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if jti == "" {
+		return nil
+	}
+	_, err := h.DB.Exec(ctx,
+		`INSERT INTO revoked_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3)
+		 ON CONFLICT (jti) DO NOTHING`,
+		jti, userID, time.Unix(int64(exp), 0))
+	return err
+}
+
+// POST /logout → end this session: the access token used for the request
+// stops working and, if given ({"refresh_token"}), so does its refresh
+// token family
+func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "❌ Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.revokeAccessToken(r.Context(), r, userID); err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.RefreshToken != "" {
+		_, err := h.DB.Exec(r.Context(),
+			`UPDATE refresh_tokens SET revoked_at = NOW()
+			 WHERE revoked_at IS NULL AND family_id =
+			       (SELECT family_id FROM refresh_tokens WHERE token_hash=$1 AND user_id=$2)`,
+			utils.HashToken(req.RefreshToken), userID)
+		if err != nil {
+			http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	h.Audit.Log(r, AuditEvent{Action: AuditLogout, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Logged out"})
+}
+
+// POST /logout/all → end every session of the user: all refresh tokens
+// are revoked and access tokens issued before now are rejected
+func (h *UserHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := utils.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err := pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(r.Context(),
+			`UPDATE users SET sessions_revoked_at = $2 WHERE id=$1`, userID, utils.RevokedAt(time.Now())); err != nil {
+			return err
+		}
+		_, err := tx.Exec(r.Context(),
+			`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id=$1 AND revoked_at IS NULL`, userID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.Audit.Log(r, AuditEvent{Action: AuditLogoutAll, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Logged out of all sessions"})
+}
+
+// PurgeTokens drops expired refresh tokens and denylist entries; neither
+// can authenticate anything once past its expiry
+func (h *UserHandler) PurgeTokens(ctx context.Context) error {
+	if _, err := h.DB.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at < NOW()`); err != nil {
+		return err
+	}
+	_, err := h.DB.Exec(ctx, `DELETE FROM refresh_tokens WHERE expires_at < NOW() - INTERVAL '1 day'`)
+	return err
+}
+
+// StartPurger runs PurgeTokens every interval until ctx is done
+func (h *UserHandler) StartPurger(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := h.PurgeTokens(ctx); err != nil {
+					log.Println("⚠️ token purge failed:", err)
+				}
+			}
+		}
+	}()
+}
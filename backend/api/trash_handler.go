@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// TrashedFile is a soft-deleted file as listed by GET /trash
+type TrashedFile struct {
+	ID        int       `json:"id"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	MimeType  string    `json:"mime_type"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+// GET /trash → files the user has deleted but not yet purged
+func (h *FileHandler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT id, filename, COALESCE(total_size, size, 0), COALESCE(mime_type, ''), deleted_at
+		 FROM files
+		 WHERE user_id=$1 AND is_deleted
+		 ORDER BY deleted_at DESC`, userID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	files := []TrashedFile{}
+	for rows.Next() {
+		var f TrashedFile
+		if err := rows.Scan(&f.ID, &f.Filename, &f.Size, &f.MimeType, &f.DeletedAt); err != nil {
+			http.Error(w, "Scan Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		f.PurgeAt = f.DeletedAt.Add(h.TrashRetention)
+		files = append(files, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+// POST /trash/{id}/restore → move a file back out of the trash
+func (h *FileHandler) RestoreFile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid file id", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var fileSize int64
+	err = tx.QueryRow(r.Context(),
+		`UPDATE files SET is_deleted = false, deleted_at = NULL
+		 WHERE id=$1 AND user_id=$2 AND is_deleted
+		 RETURNING COALESCE(total_size, size, 0)`, fileID, userID).Scan(&fileSize)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ File not found in trash", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// ✅ Re-charge the bytes if trash didn't count towards the quota
+	if !h.chargesQuota(true) {
+		tag, err := tx.Exec(r.Context(),
+			`UPDATE user_storage SET used_bytes = used_bytes + $1
+			 WHERE user_id=$2 AND used_bytes + $1 <= quota_bytes`,
+			fileSize, userID)
+		if err != nil {
+			http.Error(w, "DB Error (update storage): "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "❌ Storage quota exceeded", http.StatusForbidden)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "DB Error (commit): "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditRestore, ActorID: &userID, TargetType: "file", TargetID: auditTarget(fileID)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ File restored"})
+}
+
+// DELETE /trash/{id} → permanently delete a trashed file
+func (h *FileHandler) PurgeFile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid file id", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.DB.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var ownerID int
+	err = tx.QueryRow(r.Context(),
+		`SELECT user_id FROM files WHERE id=$1 AND is_deleted FOR UPDATE`, fileID).Scan(&ownerID)
+	if err == pgx.ErrNoRows || (err == nil && ownerID != userID) {
+		http.Error(w, "❌ File not found in trash", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stale, err := h.purgeFile(r.Context(), tx, fileID)
+	if err != nil {
+		http.Error(w, "❌ Could not delete file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "DB Error (commit): "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.dropBlobs(r.Context(), stale)
+
+	h.Audit.Log(r, AuditEvent{Action: AuditPurge, ActorID: &userID, TargetType: "file", TargetID: auditTarget(fileID)})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ File deleted permanently"})
+}
+
+// PurgeTrash permanently deletes every file trashed longer than TrashRetention
+func (h *FileHandler) PurgeTrash(ctx context.Context) error {
+	cutoff := time.Now().Add(-h.TrashRetention)
+	rows, err := h.DB.Query(ctx,
+		`SELECT id FROM files WHERE is_deleted AND deleted_at <= $1`, cutoff)
+	if err != nil {
+		return err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+
+	// One transaction per file so a single failure doesn't block the rest
+	for _, id := range ids {
+		var stale []staleBlob
+		err := pgx.BeginFunc(ctx, h.DB, func(tx pgx.Tx) error {
+			// Re-check under lock: the file may have been restored meanwhile
+			var still bool
+			err := tx.QueryRow(ctx,
+				`SELECT true FROM files WHERE id=$1 AND is_deleted AND deleted_at <= $2 FOR UPDATE`,
+				id, cutoff).Scan(&still)
+			if err == pgx.ErrNoRows {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			stale, err = h.purgeFile(ctx, tx, id)
+			return err
+		})
+		if err != nil {
+			if !errors.Is(err, errFileNotFound) {
+				log.Printf("⚠️ trash purge of file %d failed: %v", id, err)
+			}
+			continue
+		}
+		h.dropBlobs(ctx, stale)
+	}
+	return nil
+}
+
+// StartPurger runs PurgeTrash and PruneVersions every interval until ctx
+// is cancelled
+func (h *FileHandler) StartPurger(ctx context.Context, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if err := h.PurgeTrash(ctx); err != nil {
+					log.Println("⚠️ trash purge failed:", err)
+				}
+				if err := h.PruneVersions(ctx); err != nil {
+					log.Println("⚠️ version prune failed:", err)
+				}
+			}
+		}
+	}()
+}
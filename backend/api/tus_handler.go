@@ -0,0 +1,491 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/storage"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const tusVersion = "1.0.0"
+
+// tusFinishTimeout bounds hashing and storing a completed upload
+const tusFinishTimeout = 10 * time.Minute
+
+// TusHandler implements the tus 1.0 resumable upload protocol
+// (core + creation, termination and expiration extensions) on /uploads.
+// Partial uploads are kept on local disk under Dir; once the last byte
+// arrives the file goes through the same dedup + quota path as POST /files.
+type TusHandler struct {
+	DB     *pgxpool.Pool
+	Files  *FileHandler
+	Dir    string
+	Expiry time.Duration
+
+	locks sync.Map // upload id -> *sync.Mutex
+}
+
+func (h *TusHandler) partPath(id string) string {
+	return filepath.Join(h.Dir, id+".part")
+}
+
+// lock guards an upload against concurrent PATCH/DELETE requests
+func (h *TusHandler) lock(id string) (func(), bool) {
+	m, _ := h.locks.LoadOrStore(id, &sync.Mutex{})
+	mu := m.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+// tusHeaders sets the headers every tus response carries and rejects
+// requests speaking another protocol version.
+func tusHeaders(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "❌ Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// TusDiscovery answers tus OPTIONS requests on /uploads that are not CORS
+// preflights, so they don't get swallowed by the CORS middleware.
+func TusDiscovery(next http.Handler, h *TusHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions && strings.HasPrefix(r.URL.Path, "/uploads") &&
+			r.Header.Get("Access-Control-Request-Method") == "" {
+			h.Options(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// OPTIONS /uploads → advertise protocol version and extensions
+func (h *TusHandler) Options(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w, r)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination,expiration")
+	if h.Files.MaxUploadSize > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.Files.MaxUploadSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseMetadata decodes an Upload-Metadata header ("key base64,key2 base64")
+func parseMetadata(header string) map[string]string {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		kv := strings.Fields(pair)
+		if len(kv) == 0 {
+			continue
+		}
+		val := ""
+		if len(kv) > 1 {
+			if b, err := base64.StdEncoding.DecodeString(kv[1]); err == nil {
+				val = string(b)
+			}
+		}
+		meta[kv[0]] = val
+	}
+	return meta
+}
+
+// POST /uploads → create a new upload (creation extension)
+func (h *TusHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if !tusHeaders(w, r) {
+		return
+	}
+	userID, ok := h.Files.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "❌ Missing or invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	// Reject up front if the finished upload could never fit
+	used, quota, err := h.Files.userQuota(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "DB Error ("+err.Error()+")", http.StatusInternalServerError)
+		return
+	}
+	if limit, errLimit := h.Files.uploadLimit(quota - used); length > limit {
+		writeUploadError(w, errLimit)
+		return
+	}
+
+	meta := parseMetadata(r.Header.Get("Upload-Metadata"))
+	filename := meta["filename"]
+	if filename == "" {
+		filename = meta["name"]
+	}
+	if filename == "" {
+		http.Error(w, "❌ Upload-Metadata must include filename", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.Files.parseFolderID(r.Context(), userID, meta["folder_id"]); err != nil {
+		http.Error(w, "❌ "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "❌ Could not create upload", http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(buf)
+
+	if err := os.MkdirAll(h.Dir, 0o700); err != nil {
+		http.Error(w, "❌ Could not create upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f, err := os.OpenFile(h.partPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		http.Error(w, "❌ Could not create upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	expires := time.Now().Add(h.Expiry)
+	_, err = h.DB.Exec(r.Context(),
+		`INSERT INTO tus_uploads (id, user_id, filename, upload_length, upload_offset, metadata, expires_at)
+		 VALUES ($1, $2, $3, $4, 0, $5, $6)`,
+		id, userID, filename, length, r.Header.Get("Upload-Metadata"), expires)
+	if err != nil {
+		os.Remove(h.partPath(id))
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/uploads/"+id)
+	w.Header().Set("Upload-Expires", expires.UTC().Format(http.TimeFormat))
+
+	// An empty file is complete as soon as it exists; no PATCH will follow
+	if length == 0 {
+		u := &tusUpload{ID: id, UserID: userID, Filename: filename, Metadata: r.Header.Get("Upload-Metadata"), Expires: expires}
+		if !h.complete(w, r, u) {
+			return
+		}
+		w.Header().Set("Upload-Offset", "0")
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+type tusUpload struct {
+	ID       string
+	UserID   int
+	Filename string
+	Length   int64
+	Offset   int64
+	Metadata string
+	Expires  time.Time
+}
+
+// load fetches an unexpired upload owned by the caller
+func (h *TusHandler) load(ctx context.Context, id string, userID int) (*tusUpload, error) {
+	u := &tusUpload{ID: id}
+	err := h.DB.QueryRow(ctx,
+		`SELECT user_id, filename, upload_length, upload_offset, COALESCE(metadata, ''), expires_at
+		 FROM tus_uploads WHERE id=$1 AND expires_at > NOW()`, id,
+	).Scan(&u.UserID, &u.Filename, &u.Length, &u.Offset, &u.Metadata, &u.Expires)
+	if err != nil {
+		return nil, err
+	}
+	if u.UserID != userID {
+		return nil, pgx.ErrNoRows
+	}
+	return u, nil
+}
+
+// HEAD /uploads/{id} → current offset
+func (h *TusHandler) Head(w http.ResponseWriter, r *http.Request) {
+	if !tusHeaders(w, r) {
+		return
+	}
+	userID, ok := h.Files.getUserID(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	u, err := h.load(r.Context(), mux.Vars(r)["id"], userID)
+	if err == pgx.ErrNoRows {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+	if u.Metadata != "" {
+		w.Header().Set("Upload-Metadata", u.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// PATCH /uploads/{id} → append a chunk at Upload-Offset
+func (h *TusHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	if !tusHeaders(w, r) {
+		return
+	}
+	userID, ok := h.Files.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "❌ Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "❌ Missing or invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	unlock, ok := h.lock(id)
+	if !ok {
+		http.Error(w, "❌ Upload is busy", http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	u, err := h.load(r.Context(), id, userID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ Upload not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if offset != u.Offset {
+		http.Error(w, "❌ Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(h.partPath(id), os.O_WRONLY, 0o600)
+	if err != nil {
+		http.Error(w, "❌ Could not open upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Drop anything past the recorded offset left by an interrupted chunk
+	if err := f.Truncate(u.Offset); err == nil {
+		_, err = f.Seek(u.Offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		http.Error(w, "❌ Could not open upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Keep whatever arrived even if the connection drops mid-chunk
+	n, copyErr := io.Copy(f, &limitReader{r: r.Body, n: u.Length - u.Offset, errLimit: errUploadTooLarge})
+	closeErr := f.Close()
+	if closeErr != nil {
+		http.Error(w, "❌ Could not write upload: "+closeErr.Error(), http.StatusInternalServerError)
+		return
+	}
+	if errors.Is(copyErr, errUploadTooLarge) {
+		http.Error(w, "❌ Chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	u.Offset += n
+	u.Expires = time.Now().Add(h.Expiry)
+	// Use a fresh context: the client may already be gone
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = h.DB.Exec(ctx,
+		`UPDATE tus_uploads SET upload_offset=$1, expires_at=$2 WHERE id=$3`,
+		u.Offset, u.Expires, id)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if copyErr != nil {
+		http.Error(w, "❌ Could not read chunk: "+copyErr.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+
+	if u.Offset == u.Length && !h.complete(w, r, u) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// complete finishes an upload whose last byte has arrived, setting
+// X-File-ID on success. It responds with the error and returns false
+// otherwise; the part file stays so the client can retry.
+func (h *TusHandler) complete(w http.ResponseWriter, r *http.Request, u *tusUpload) bool {
+	// Like the offset write, this must not be cut short by the client
+	// hanging up after its last chunk
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), tusFinishTimeout)
+	defer cancel()
+	fileID, err := h.finish(ctx, u)
+	if err != nil {
+		writeUploadError(w, err)
+		return false
+	}
+	h.Files.Audit.Log(r, AuditEvent{Action: AuditUpload, ActorID: &u.UserID, TargetType: "file", TargetID: auditTarget(fileID),
+		Details: map[string]interface{}{"filename": u.Filename, "size": u.Length, "resumable": true}})
+	w.Header().Set("X-File-ID", strconv.Itoa(fileID))
+	return true
+}
+
+// finish hashes the completed upload and hands it to the regular
+// dedup + quota logic, then forgets the tus state
+func (h *TusHandler) finish(ctx context.Context, u *tusUpload) (int, error) {
+	f, err := os.Open(h.partPath(u.ID))
+	if err != nil {
+		return 0, err
+	}
+	hasher := sha256.New()
+	head := &headWriter{}
+	_, err = io.Copy(io.MultiWriter(hasher, head), f)
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+	fileHash := hex.EncodeToString(hasher.Sum(nil))
+
+	// tus clients send the browser's idea of the type as "filetype"
+	mimeType, err := h.Files.resolveMIME(head.buf, parseMetadata(u.Metadata)["filetype"], u.Filename)
+	if err != nil {
+		return 0, err
+	}
+
+	folderID, err := h.Files.parseFolderID(ctx, u.UserID, parseMetadata(u.Metadata)["folder_id"])
+	if err != nil {
+		return 0, err
+	}
+
+	fileID, err := h.Files.storeFile(ctx, u.UserID, folderID, u.Filename, mimeType, fileHash, u.Length, func(key string) error {
+		return storage.PutFile(ctx, h.Files.Store, key, h.partPath(u.ID))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	h.remove(ctx, u.ID)
+	return fileID, nil
+}
+
+// remove deletes an upload's row and partial data
+func (h *TusHandler) remove(ctx context.Context, id string) error {
+	if _, err := h.DB.Exec(ctx, `DELETE FROM tus_uploads WHERE id=$1`, id); err != nil {
+		return err
+	}
+	h.locks.Delete(id)
+	if err := os.Remove(h.partPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// DELETE /uploads/{id} → abort an upload (termination extension)
+func (h *TusHandler) Terminate(w http.ResponseWriter, r *http.Request) {
+	if !tusHeaders(w, r) {
+		return
+	}
+	userID, ok := h.Files.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	unlock, ok := h.lock(id)
+	if !ok {
+		http.Error(w, "❌ Upload is busy", http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	if _, err := h.load(r.Context(), id, userID); err == pgx.ErrNoRows {
+		http.Error(w, "❌ Upload not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.remove(r.Context(), id); err != nil {
+		http.Error(w, "❌ Could not delete upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeExpired removes uploads whose expiry has passed (expiration extension)
+func (h *TusHandler) PurgeExpired(ctx context.Context) error {
+	rows, err := h.DB.Query(ctx, `SELECT id FROM tus_uploads WHERE expires_at <= NOW()`)
+	if err != nil {
+		return err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		unlock, ok := h.lock(id)
+		if !ok {
+			continue // a PATCH is in flight and will refresh the expiry
+		}
+		err := h.remove(ctx, id)
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartPurger runs PurgeExpired every interval until ctx is cancelled
+func (h *TusHandler) StartPurger(ctx context.Context, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if err := h.PurgeExpired(ctx); err != nil {
+					log.Println("⚠️ tus purge failed:", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+)
+
+var (
+	errUploadTooLarge = errors.New("upload exceeds the maximum allowed size")
+	errQuotaExceeded  = errors.New("storage quota exceeded")
+	errNoFilePart     = errors.New(`multipart form has no "file" part`)
+)
+
+// spooledUpload is an uploaded file that has been streamed to a temp file
+// and hashed on the way through.
+type spooledUpload struct {
+	Filename string
+	Path     string
+	Size     int64
+	Hash     string
+	Head     []byte            // first bytes, for content sniffing
+	MimeType string            // Content-Type declared for the part
+	Fields   map[string]string // plain form fields sent before the file part
+}
+
+// headWriter keeps the first sniffLen bytes written to it
+type headWriter struct {
+	buf []byte
+}
+
+func (hw *headWriter) Write(p []byte) (int, error) {
+	if room := sniffLen - len(hw.buf); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		hw.buf = append(hw.buf, p[:room]...)
+	}
+	return len(p), nil
+}
+
+// limitReader fails with errLimit as soon as more than n bytes are read,
+// so oversize uploads are rejected without reading them to the end.
+type limitReader struct {
+	r        io.Reader
+	n        int64
+	errLimit error
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, l.errLimit
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, l.errLimit
+	}
+	return n, err
+}
+
+// uploadLimit caps a single upload at the configured maximum or the
+// remaining quota, whichever is smaller, and returns the error to report
+// when that cap is crossed.
+func (h *FileHandler) uploadLimit(remaining int64) (int64, error) {
+	if h.MaxUploadSize > 0 && h.MaxUploadSize < remaining {
+		return h.MaxUploadSize, errUploadTooLarge
+	}
+	return remaining, errQuotaExceeded
+}
+
+// spoolUpload streams the "file" part of a multipart request to a temp file,
+// hashing it with SHA-256 as it goes. Reading stops with the limit error as
+// soon as more than limit bytes arrive. The caller removes Path.
+func (h *FileHandler) spoolUpload(r *http.Request, limit int64, errLimit error) (*spooledUpload, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errNoFilePart
+		} else if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() != "file" {
+			// Small text fields only; anything else is ignored
+			if part.FileName() == "" {
+				v, _ := io.ReadAll(io.LimitReader(part, 4096))
+				fields[part.FormName()] = string(v)
+			}
+			part.Close()
+			continue
+		}
+		defer part.Close()
+
+		up, err := h.spool(&limitReader{r: part, n: limit, errLimit: errLimit})
+		if err != nil {
+			return nil, err
+		}
+		up.Filename = part.FileName()
+		up.MimeType = part.Header.Get("Content-Type")
+		up.Fields = fields
+		return up, nil
+	}
+}
+
+// spool copies src to a temp file while hashing it
+func (h *FileHandler) spool(src io.Reader) (*spooledUpload, error) {
+	tmp, err := os.CreateTemp(h.TempDir, "filevault-upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	head := &headWriter{}
+	size, err := io.Copy(tmp, io.TeeReader(src, io.MultiWriter(hasher, head)))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return &spooledUpload{
+		Path: tmp.Name(),
+		Size: size,
+		Hash: hex.EncodeToString(hasher.Sum(nil)),
+		Head: head.buf,
+	}, nil
+}
+
+// writeUploadError maps upload failures to HTTP responses
+func writeUploadError(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errQuotaExceeded):
+		http.Error(w, "❌ Storage quota exceeded", http.StatusForbidden)
+	case errors.Is(err, errUploadTooLarge), errors.As(err, &maxErr):
+		http.Error(w, "❌ File too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errMIMEMismatch):
+		http.Error(w, "❌ "+err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, errNoFilePart), errors.Is(err, http.ErrNotMultipart):
+		http.Error(w, "❌ Could not get file: "+err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "❌ Could not save file: "+err.Error(), http.StatusInternalServerError)
+	}
+}
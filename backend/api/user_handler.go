@@ -2,15 +2,45 @@ package api
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/Dashsouradeep/balkanid-filevault/backend/mail"
 	"github.com/Dashsouradeep/balkanid-filevault/backend/utils"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type UserHandler struct {
-	DB     *pgxpool.Pool
-	Secret string
+	DB      *pgxpool.Pool
+	Secret  string
+	Audit   *Auditor
+	Proxies TrustedProxies
+
+	// Lifetimes of access JWTs and of refresh tokens
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+
+	// Issuer shown in authenticator apps; Now overrides the clock used
+	// for TOTP checks (nil = time.Now)
+	MFAIssuer string
+	Now       func() time.Time
+
+	// Verification and password reset mails link to pages under AppURL
+	Mailer mail.Mailer
+	AppURL string
+
+	// Brute-force protection: LoginLimit throttles attempts per account
+	// (by email); LockoutThreshold consecutive failures lock the account
+	// (0 = never)
+	LoginLimit       *Limiter
+	LockoutThreshold int
+
+	// Passwords refused at registration and reset (nil = built-in list)
+	Breached *BreachedList
 }
 
 // Register - create a new user
@@ -24,6 +54,46 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
 		return
 	}
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
+
+	// Validate every field, so the client can show all problems at once
+	var errs []FieldError
+	for _, fe := range []*FieldError{
+		checkUsername(req.Username),
+		checkEmail(req.Email),
+		h.Breached.checkPassword(req.Password, req.Username, req.Email),
+	} {
+		if fe != nil {
+			errs = append(errs, *fe)
+		}
+	}
+	if len(errs) > 0 {
+		fieldErrors(w, http.StatusBadRequest, "Validation failed", errs)
+		return
+	}
+
+	// Duplicates get a 409 naming the field; emails compare case-insensitively
+	var usernameTaken, emailTaken bool
+	err := h.DB.QueryRow(r.Context(),
+		`SELECT EXISTS (SELECT 1 FROM users WHERE username=$1),
+		        EXISTS (SELECT 1 FROM users WHERE lower(email)=lower($2))`,
+		req.Username, req.Email,
+	).Scan(&usernameTaken, &emailTaken)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if usernameTaken {
+		errs = append(errs, FieldError{"username", "taken", "username is already taken"})
+	}
+	if emailTaken {
+		errs = append(errs, FieldError{"email", "taken", "email is already registered"})
+	}
+	if len(errs) > 0 {
+		fieldErrors(w, http.StatusConflict, "Already registered", errs)
+		return
+	}
 
 	// Hash password
 	hashed, err := utils.HashPassword(req.Password)
@@ -40,7 +110,11 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 		req.Username, req.Email, hashed,
 	).Scan(&userID)
 
-	if err != nil {
+	if fe, ok := conflictField(err); ok {
+		// Lost a race with another registration
+		fieldErrors(w, http.StatusConflict, "Already registered", []FieldError{fe})
+		return
+	} else if err != nil {
 		http.Error(w, "DB Error (insert user): "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -56,10 +130,28 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Invites to this address wait until it is verified (see VerifyEmail)
+	if err := h.sendVerification(r.Context(), userID, req.Email); err != nil {
+		log.Printf("⚠️ sending verification mail to user %d failed: %v", userID, err)
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditRegister, ActorID: &userID, TargetType: "user", TargetID: auditTarget(userID),
+		Details: map[string]interface{}{"username": req.Username, "email": req.Email}})
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "✅ User registered successfully"})
 }
 
+// dummyPasswordHash is compared against for unknown emails. It is made
+// with utils.HashPassword so its bcrypt cost matches real hashes.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := utils.HashPassword("filevault-no-such-account")
+	if err != nil {
+		log.Println("⚠️ making dummy password hash failed:", err)
+	}
+	return hash
+})
+
 // Login user
 func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req struct {
@@ -70,29 +162,101 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "❌ Invalid input", http.StatusBadRequest)
 		return
 	}
+	if ok, retry := h.LoginLimit.Allow(strings.ToLower(req.Email)); !ok {
+		tooManyRequests(w, retry, "❌ Too many login attempts")
+		return
+	}
 
 	var id int
 	var email string
 	var hashed string
+	var role string
+	var disabled, mfa bool
+	var lockedUntil *time.Time
+	// Emails match case-insensitively, as in Register; an exact match wins
+	// for old accounts that differ only in case
 	err := h.DB.QueryRow(r.Context(),
-		`SELECT id, email, password_hash FROM users WHERE email=$1`, req.Email).
-		Scan(&id, &email, &hashed)
+		`SELECT id, email, password_hash, COALESCE(role, 'user'), disabled_at IS NOT NULL, mfa_enabled_at IS NOT NULL,
+		        locked_until
+		 FROM users WHERE lower(email)=lower($1)
+		 ORDER BY email = $1 DESC LIMIT 1`, req.Email).
+		Scan(&id, &email, &hashed, &role, &disabled, &mfa, &lockedUntil)
 	if err != nil {
+		// Take as long as a wrong password would, so timing doesn't
+		// reveal which emails have accounts
+		utils.CheckPasswordHash(req.Password, dummyPasswordHash())
+		h.Audit.Log(r, AuditEvent{Action: AuditLoginFailed, TargetType: "user",
+			Details: map[string]interface{}{"email": req.Email, "reason": "unknown email"}})
+		http.Error(w, "❌ Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	// Anyone can lock an account, so a locked one must look like a wrong
+	// password (same answer, same bcrypt cost) or it would confirm that the
+	// email is registered. Only the audit entry says why.
+	passwordOK := utils.CheckPasswordHash(req.Password, hashed)
+	if lockedUntil != nil && lockedUntil.After(h.now()) {
+		h.Audit.Log(r, AuditEvent{Action: AuditLoginFailed, ActorID: &id, TargetType: "user", TargetID: auditTarget(id),
+			Details: map[string]interface{}{"email": req.Email, "reason": "account locked", "locked_until": lockedUntil}})
 		http.Error(w, "❌ Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	if !utils.CheckPasswordHash(req.Password, hashed) {
+	if !passwordOK {
+		h.Audit.Log(r, AuditEvent{Action: AuditLoginFailed, ActorID: &id, TargetType: "user", TargetID: auditTarget(id),
+			Details: map[string]interface{}{"email": req.Email, "reason": "wrong password"}})
+		h.recordLoginFailure(r, id)
 		http.Error(w, "❌ Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	if disabled {
+		h.Audit.Log(r, AuditEvent{Action: AuditLoginFailed, ActorID: &id, TargetType: "user", TargetID: auditTarget(id),
+			Details: map[string]interface{}{"email": req.Email, "reason": "account disabled"}})
+		http.Error(w, "❌ Account disabled", http.StatusForbidden)
+		return
+	}
+
+	h.finishLogin(w, r, id, email, role, mfa, nil)
+}
+
+// finishLogin answers a successful first login step: session tokens, or
+// with MFA on, a challenge for /login/mfa. details go to the audit entry.
+func (h *UserHandler) finishLogin(w http.ResponseWriter, r *http.Request, id int, email, role string, mfa bool, details map[string]interface{}) {
+	// With MFA on, the first step only earns a challenge for /login/mfa
+	if mfa {
+		challenge, err := utils.GenerateChallengeToken(id, "mfa", h.Secret, mfaChallengeTTL)
+		if err != nil {
+			http.Error(w, "❌ Failed to generate token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    challenge,
+			"expires_in":   int(mfaChallengeTTL.Seconds()),
+		})
+		return
+	}
 
-	token, err := utils.GenerateJWT(id, email, h.Secret)
+	var resp tokenResponse
+	err := pgx.BeginFunc(r.Context(), h.DB, func(tx pgx.Tx) error {
+		if err := clearLoginFailures(r.Context(), tx, id); err != nil {
+			return err
+		}
+		var err error
+		resp, err = h.issueTokens(r.Context(), tx, r, id, email, role, "")
+		return err
+	})
 	if err != nil {
 		http.Error(w, "❌ Failed to generate token: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	h.Audit.Log(r, AuditEvent{Action: AuditLogin, ActorID: &id, TargetType: "user", TargetID: auditTarget(id),
+		Details: details})
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
 }
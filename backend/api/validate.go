@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// FieldError is one failed check on one input field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"` // required, invalid, too_short, too_long, breached, taken
+	Message string `json:"message"`
+}
+
+// fieldErrors responds with every failing field:
+// {"error": msg, "fields": [{"field", "code", "message"}, ...]}
+func fieldErrors(w http.ResponseWriter, status int, msg string, errs []FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": msg, "fields": errs})
+}
+
+// Registration rules
+const (
+	usernameMin = 3
+	usernameMax = 32
+	emailMax    = 255
+	passwordMin = 8
+	passwordMax = 72 // bcrypt ignores anything longer
+)
+
+// validUsername allows letters, digits, '.', '_' and '-', starting with a
+// letter or digit
+func validUsername(s string) bool {
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case i > 0 && (c == '.' || c == '_' || c == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func checkUsername(s string) *FieldError {
+	n := utf8.RuneCountInString(s)
+	switch {
+	case s == "":
+		return &FieldError{"username", "required", "username is required"}
+	case n < usernameMin:
+		return &FieldError{"username", "too_short", fmt.Sprintf("username must be at least %d characters", usernameMin)}
+	case n > usernameMax:
+		return &FieldError{"username", "too_long", fmt.Sprintf("username must be at most %d characters", usernameMax)}
+	case !validUsername(s):
+		return &FieldError{"username", "invalid", "username may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit"}
+	}
+	return nil
+}
+
+func checkEmail(s string) *FieldError {
+	if s == "" {
+		return &FieldError{"email", "required", "email is required"}
+	}
+	if len(s) > emailMax {
+		return &FieldError{"email", "too_long", fmt.Sprintf("email must be at most %d characters", emailMax)}
+	}
+	// A bare address only: no display name, and a dotted domain
+	addr, err := mail.ParseAddress(s)
+	_, domain, _ := strings.Cut(s, "@")
+	if err != nil || addr.Address != s || !strings.Contains(domain, ".") || strings.HasSuffix(domain, ".") {
+		return &FieldError{"email", "invalid", "email is not a valid address"}
+	}
+	return nil
+}
+
+// checkPassword applies the password policy; username and email, when
+// known, may not be used as the password
+func (b *BreachedList) checkPassword(password, username, email string) *FieldError {
+	switch {
+	case password == "":
+		return &FieldError{"password", "required", "password is required"}
+	case utf8.RuneCountInString(password) < passwordMin:
+		return &FieldError{"password", "too_short", fmt.Sprintf("password must be at least %d characters", passwordMin)}
+	case len(password) > passwordMax:
+		return &FieldError{"password", "too_long", fmt.Sprintf("password must be at most %d bytes", passwordMax)}
+	case strings.EqualFold(password, username) || strings.EqualFold(password, email):
+		return &FieldError{"password", "invalid", "password must not be your username or email"}
+	case b.Contains(password):
+		return &FieldError{"password", "breached", "password appears in a list of breached passwords; choose another"}
+	}
+	return nil
+}
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// BreachedList is a local set of known-breached passwords. Entries are
+// plain passwords (matched ignoring case) or SHA-1 hex digests as in the
+// Pwned Passwords dumps ("HASH" or "HASH:count"). A nil list still
+// checks the built-in common passwords.
+type BreachedList struct {
+	plain map[string]bool
+	sha1  map[string]bool
+}
+
+// LoadBreachedList reads the built-in list plus, if path isn't empty, the
+// file at path (one entry per line, '#' starts a comment)
+func LoadBreachedList(path string) (*BreachedList, error) {
+	b := &BreachedList{plain: map[string]bool{}, sha1: map[string]bool{}}
+	if err := b.read(strings.NewReader(commonPasswords)); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return b, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return b, b.read(f)
+}
+
+func (b *BreachedList) read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); len(hash) == 40 && isHex(hash) {
+			b.sha1[strings.ToUpper(hash)] = true
+			continue
+		}
+		b.plain[strings.ToLower(line)] = true
+	}
+	return sc.Err()
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+var defaultBreached, defaultBreachedErr = LoadBreachedList("")
+
+// Contains reports whether password is on the list
+func (b *BreachedList) Contains(password string) bool {
+	if b == nil {
+		if defaultBreachedErr != nil {
+			return false
+		}
+		b = defaultBreached
+	}
+	if b.plain[strings.ToLower(password)] {
+		return true
+	}
+	sum := sha1.Sum([]byte(password))
+	return b.sha1[strings.ToUpper(hex.EncodeToString(sum[:]))]
+}
+
+// conflictField maps a unique violation on users to the field it concerns
+func conflictField(err error) (FieldError, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return FieldError{}, false
+	}
+	switch {
+	case strings.Contains(pgErr.ConstraintName, "email"):
+		return FieldError{"email", "taken", "email is already registered"}, true
+	case strings.Contains(pgErr.ConstraintName, "username"):
+		return FieldError{"username", "taken", "username is already taken"}, true
+	}
+	return FieldError{}, false
+}
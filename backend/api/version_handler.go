@@ -0,0 +1,483 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Dashsouradeep/balkanid-filevault/backend/storage"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// FileVersion is one entry of GET /files/{id}/versions
+type FileVersion struct {
+	Version   int       `json:"version"`
+	Size      int64     `json:"size"`
+	FileHash  string    `json:"file_hash"`
+	MimeType  string    `json:"mime_type"`
+	AuthorID  int       `json:"author_id"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+	Current   bool      `json:"current"`
+
+	// Diff metadata against the previous version in the list
+	SizeDelta   int64 `json:"size_delta"`
+	SameAsPrev  bool  `json:"same_as_previous"`
+	DuplicateOf int   `json:"duplicate_of,omitempty"` // oldest version with identical content
+}
+
+// pruneVersions removes non-current versions of fileID beyond VersionKeep
+// (counting the current one) or older than VersionMaxAge, returning their
+// blob references and any bytes no other version of the file still uses.
+// Blobs left without references are returned for dropBlobs.
+func (h *FileHandler) pruneVersions(ctx context.Context, tx pgx.Tx, fileID int) ([]staleBlob, error) {
+	if h.VersionKeep <= 0 && h.VersionMaxAge <= 0 {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT v.id, v.created_at
+		 FROM file_versions v JOIN files f ON f.id = v.file_id
+		 WHERE v.file_id=$1 AND v.version <> f.current_version
+		 ORDER BY v.version DESC`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	type old struct {
+		ID        int
+		CreatedAt time.Time
+	}
+	olds, err := pgx.CollectRows(rows, pgx.RowToStructByPos[old])
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []staleBlob
+	for i, v := range olds {
+		tooMany := h.VersionKeep > 0 && i+1 >= h.VersionKeep
+		tooOld := h.VersionMaxAge > 0 && time.Since(v.CreatedAt) > h.VersionMaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		blobs, err := h.removeVersion(ctx, tx, fileID, v.ID)
+		if err != nil {
+			return nil, err
+		}
+		stale = append(stale, blobs...)
+	}
+	return stale, nil
+}
+
+// removeVersion deletes a single non-current version inside tx, returning
+// its blob for dropBlobs if that was the last reference
+func (h *FileHandler) removeVersion(ctx context.Context, tx pgx.Tx, fileID, versionID int) ([]staleBlob, error) {
+	var hashID *int
+	var size int64
+	err := tx.QueryRow(ctx,
+		`DELETE FROM file_versions WHERE id=$1 RETURNING file_hash_id, size`, versionID,
+	).Scan(&hashID, &size)
+	if err != nil {
+		return nil, fmt.Errorf("delete version: %w", err)
+	}
+	if hashID == nil {
+		return nil, nil
+	}
+
+	// Only give bytes back if no other version of this file has the same content
+	var ownerID int
+	var deleted, stillUsed bool
+	err = tx.QueryRow(ctx,
+		`SELECT f.user_id, f.is_deleted,
+		        EXISTS (SELECT 1 FROM file_versions WHERE file_id=$1 AND file_hash_id=$2)
+		 FROM files f WHERE f.id=$1`, fileID, *hashID,
+	).Scan(&ownerID, &deleted, &stillUsed)
+	if err != nil {
+		return nil, err
+	}
+	if !stillUsed {
+		_, err = tx.Exec(ctx,
+			`UPDATE files SET total_size = GREATEST(COALESCE(total_size, 0) - $2, 0) WHERE id=$1`,
+			fileID, size)
+		if err != nil {
+			return nil, err
+		}
+		if h.chargesQuota(deleted) {
+			if err := refundQuota(ctx, tx, ownerID, size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return h.releaseFile(ctx, tx, hashID, "")
+}
+
+// POST /files/{id}/versions → upload new content for an existing file
+func (h *FileHandler) UploadVersion(w http.ResponseWriter, r *http.Request) {
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid file id", http.StatusBadRequest)
+		return
+	}
+	h.uploadVersion(w, r, fileID, nil)
+}
+
+// uploadVersion handles a new-version upload. up is nil when the request
+// body hasn't been read yet.
+func (h *FileHandler) uploadVersion(w http.ResponseWriter, r *http.Request, fileID int, up *spooledUpload) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Owner, or a share with write permission
+	ownerID, _, ok := h.requirePermission(w, r, fileID, userID, PermWrite)
+	if !ok {
+		return
+	}
+	var filename string
+	err := h.DB.QueryRow(r.Context(),
+		`SELECT filename FROM files WHERE id=$1`, fileID).Scan(&filename)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if up == nil {
+		// The owner's quota pays for new versions
+		used, quota, err := h.userQuota(r.Context(), ownerID)
+		if err != nil {
+			http.Error(w, "DB Error ("+err.Error()+")", http.StatusInternalServerError)
+			return
+		}
+		limit, errLimit := h.uploadLimit(quota - used)
+		up, err = h.spoolUpload(r, limit, errLimit)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		defer os.Remove(up.Path)
+	}
+
+	// Versions keep the file's name; the type is checked against it
+	mimeType, err := h.resolveMIME(up.Head, up.MimeType, filename)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	version, err := h.addVersion(r.Context(), fileID, userID, mimeType, up.Hash, up.Size, func(key string) error {
+		return storage.PutFile(r.Context(), h.Store, key, up.Path)
+	})
+	if errors.Is(err, errFileNotFound) {
+		http.Error(w, "❌ File not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditUploadVersion, ActorID: &userID, TargetType: "file", TargetID: auditTarget(fileID),
+		Details: map[string]interface{}{"version": version, "size": up.Size, "hash": up.Hash}})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "✅ New version uploaded",
+		"file_id": fileID,
+		"version": version,
+	})
+}
+
+// ownedFile checks that fileID exists, isn't trashed and belongs to userID
+func (h *FileHandler) ownedFile(w http.ResponseWriter, r *http.Request, userID int) (int, bool) {
+	fileID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "❌ Invalid file id", http.StatusBadRequest)
+		return 0, false
+	}
+	var ownerID int
+	err = h.DB.QueryRow(r.Context(),
+		`SELECT user_id FROM files WHERE id=$1 AND NOT is_deleted`, fileID).Scan(&ownerID)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ File not found", http.StatusNotFound)
+		return 0, false
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	if ownerID != userID {
+		http.Error(w, "❌ Forbidden", http.StatusForbidden)
+		return 0, false
+	}
+	return fileID, true
+}
+
+// versionParam parses the {version} path variable, writing a 400 if it
+// isn't a number
+func versionParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	version, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil {
+		http.Error(w, "❌ Invalid version", http.StatusBadRequest)
+		return 0, false
+	}
+	return version, true
+}
+
+// GET /files/{id}/versions → version history, newest first
+func (h *FileHandler) GetVersions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fileID, ok := h.ownedFile(w, r, userID)
+	if !ok {
+		return
+	}
+
+	rows, err := h.DB.Query(r.Context(),
+		`SELECT v.version, v.size, v.file_hash, COALESCE(v.mime_type, ''),
+		        COALESCE(v.created_by, 0), COALESCE(u.username, ''), v.created_at,
+		        v.version = f.current_version
+		 FROM file_versions v
+		 JOIN files f ON f.id = v.file_id
+		 LEFT JOIN users u ON u.id = v.created_by
+		 WHERE v.file_id=$1
+		 ORDER BY v.version ASC`, fileID)
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	versions := []FileVersion{}
+	firstSeen := map[string]int{}
+	for rows.Next() {
+		var v FileVersion
+		if err := rows.Scan(&v.Version, &v.Size, &v.FileHash, &v.MimeType,
+			&v.AuthorID, &v.Author, &v.CreatedAt, &v.Current); err != nil {
+			http.Error(w, "Scan Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n := len(versions); n > 0 {
+			prev := versions[n-1]
+			v.SizeDelta = v.Size - prev.Size
+			v.SameAsPrev = v.FileHash == prev.FileHash
+		}
+		if first, seen := firstSeen[v.FileHash]; seen {
+			v.DuplicateOf = first
+		} else {
+			firstSeen[v.FileHash] = v.Version
+		}
+		versions = append(versions, v)
+	}
+
+	// Newest first
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// GET /files/{id}/versions/{version} → download a specific version
+func (h *FileHandler) DownloadVersion(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fileID, ok := h.ownedFile(w, r, userID)
+	if !ok {
+		return
+	}
+	version, ok := versionParam(w, r)
+	if !ok {
+		return
+	}
+
+	var m blobMeta
+	err := h.DB.QueryRow(r.Context(),
+		`SELECT f.filename, v.file_hash, COALESCE(v.mime_type, ''), v.size, v.created_at
+		 FROM file_versions v JOIN files f ON f.id = v.file_id
+		 WHERE v.file_id=$1 AND v.version=$2`, fileID, version,
+	).Scan(&m.Filename, &m.Hash, &m.MimeType, &m.Size, &m.ModTime)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ Version not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	m.Key = storage.HashKey(m.Hash)
+	h.serveDownload(w, r, fileID, m, downloadSource{UserID: &userID, Version: &version})
+}
+
+// POST /files/{id}/versions/{version}/promote → make an old version current
+func (h *FileHandler) PromoteVersion(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fileID, ok := h.ownedFile(w, r, userID)
+	if !ok {
+		return
+	}
+	version, ok := versionParam(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := h.DB.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	// Lock the file so a concurrent upload, delete or prune can't move the
+	// current pointer or drop the version between the check and the update
+	var previous int
+	var fileHash string
+	err = tx.QueryRow(r.Context(),
+		`SELECT v.file_hash, COALESCE(f.current_version, 0)
+		 FROM file_versions v JOIN files f ON f.id = v.file_id
+		 WHERE v.file_id=$1 AND v.version=$2 AND NOT f.is_deleted
+		 FOR UPDATE OF f`, fileID, version,
+	).Scan(&fileHash, &previous)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ Version not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The version's blob is already referenced and charged; only the
+	// current pointer moves
+	_, err = tx.Exec(r.Context(),
+		`UPDATE files f
+		 SET file_hash_id = v.file_hash_id, file_hash = v.file_hash, filepath = $3,
+		     size = v.size, mime_type = v.mime_type, current_version = v.version, updated_at = NOW()
+		 FROM file_versions v
+		 WHERE f.id=$1 AND v.file_id = f.id AND v.version=$2`,
+		fileID, version, storage.HashKey(fileHash))
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "DB Error (commit): "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.Audit.Log(r, AuditEvent{Action: AuditPromote, ActorID: &userID, TargetType: "file", TargetID: auditTarget(fileID),
+		Details: map[string]interface{}{"version": version, "previous": previous, "hash": fileHash}})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Version promoted to current"})
+}
+
+// DELETE /files/{id}/versions/{version} → drop an old version
+func (h *FileHandler) DeleteVersion(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.getUserID(r)
+	if !ok {
+		http.Error(w, "❌ Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	fileID, ok := h.ownedFile(w, r, userID)
+	if !ok {
+		return
+	}
+	version, ok := versionParam(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := h.DB.Begin(r.Context())
+	if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var versionID int
+	var current bool
+	err = tx.QueryRow(r.Context(),
+		`SELECT v.id, v.version = f.current_version
+		 FROM file_versions v JOIN files f ON f.id = v.file_id
+		 WHERE v.file_id=$1 AND v.version=$2
+		 FOR UPDATE OF f`, fileID, version,
+	).Scan(&versionID, &current)
+	if err == pgx.ErrNoRows {
+		http.Error(w, "❌ Version not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB Error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if current {
+		http.Error(w, "❌ Cannot delete the current version", http.StatusConflict)
+		return
+	}
+
+	stale, err := h.removeVersion(r.Context(), tx, fileID, versionID)
+	if err != nil {
+		http.Error(w, "❌ Could not delete version: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		http.Error(w, "DB Error (commit): "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.dropBlobs(r.Context(), stale)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "✅ Version deleted"})
+}
+
+// PruneVersions applies the age policy to every file's history
+func (h *FileHandler) PruneVersions(ctx context.Context) error {
+	if h.VersionMaxAge <= 0 {
+		return nil
+	}
+	rows, err := h.DB.Query(ctx,
+		`SELECT DISTINCT v.file_id
+		 FROM file_versions v JOIN files f ON f.id = v.file_id
+		 WHERE v.version <> f.current_version AND v.created_at <= $1`,
+		time.Now().Add(-h.VersionMaxAge))
+	if err != nil {
+		return err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		var stale []staleBlob
+		err := pgx.BeginFunc(ctx, h.DB, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, `SELECT 1 FROM files WHERE id=$1 FOR UPDATE`, id); err != nil {
+				return err
+			}
+			var err error
+			stale, err = h.pruneVersions(ctx, tx, id)
+			return err
+		})
+		if err != nil {
+			log.Printf("⚠️ version prune of file %d failed: %v", id, err)
+			continue
+		}
+		h.dropBlobs(ctx, stale)
+	}
+	return nil
+}
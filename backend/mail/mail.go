@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email for the account flows (verification, password reset)
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Config selects and configures a Mailer
+type Config struct {
+	Driver string // "log" (default), "memory" or "smtp"
+	From   string
+
+	// smtp driver
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// New builds the mailer described by cfg
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Driver {
+	case "", "log":
+		return Log{}, nil
+	case "memory":
+		return &Memory{}, nil
+	case "smtp":
+		return NewSMTP(SMTPOptions{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		})
+	default:
+		return nil, fmt.Errorf("mail: unknown driver %q", cfg.Driver)
+	}
+}
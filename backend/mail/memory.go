@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Memory keeps sent messages in memory, for tests
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Sent returns a copy of the messages sent so far
+func (m *Memory) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// Log writes messages to the server log instead of sending them, for
+// development without a mail server
+type Log struct{}
+
+func (Log) Send(ctx context.Context, msg Message) error {
+	log.Printf("📧 To: %s | Subject: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,76 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPOptions configures the SMTP driver
+type SMTPOptions struct {
+	Host     string
+	Port     string // default 587
+	Username string // no AUTH when empty
+	Password string
+	From     string
+}
+
+// SMTP sends mail through an SMTP server, using STARTTLS when offered
+type SMTP struct {
+	addr     string
+	auth     smtp.Auth
+	from     string // header, may include a display name
+	envelope string // bare address for MAIL FROM
+}
+
+// NewSMTP validates opts and returns the driver
+func NewSMTP(opts SMTPOptions) (*SMTP, error) {
+	if opts.Host == "" {
+		return nil, errors.New("mail: SMTP host is required")
+	}
+	if opts.From == "" {
+		return nil, errors.New("mail: sender address is required")
+	}
+	from, err := netmail.ParseAddress(opts.From)
+	if err != nil {
+		return nil, fmt.Errorf("mail: invalid sender address: %w", err)
+	}
+	if opts.Port == "" {
+		opts.Port = "587"
+	}
+	s := &SMTP{addr: net.JoinHostPort(opts.Host, opts.Port), from: from.String(), envelope: from.Address}
+	if opts.Username != "" {
+		s.auth = smtp.PlainAuth("", opts.Username, opts.Password, opts.Host)
+	}
+	return s, nil
+}
+
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return errors.New("mail: invalid header value")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+
+	// net/smtp has no context support; at least honour cancellation
+	// before connecting
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return smtp.SendMail(s.addr, s.auth, s.envelope, []string{m.To}, buf.Bytes())
+}
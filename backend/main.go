@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 
 	"github.com/Dashsouradeep/balkanid-filevault/backend/api"
 	"github.com/Dashsouradeep/balkanid-filevault/backend/db"
+	"github.com/Dashsouradeep/balkanid-filevault/backend/mail"
+	"github.com/Dashsouradeep/balkanid-filevault/backend/storage"
 )
 
 func main() {
@@ -23,37 +28,263 @@ func main() {
 		secret = "supersecret" // fallback for dev
 	}
 
+	// Blob storage (local disk by default, S3/MinIO via STORAGE_DRIVER=s3)
+	store, err := storage.New(storage.Config{
+		Driver:      db.GetEnv("STORAGE_DRIVER", "local"),
+		LocalRoot:   db.GetEnv("STORAGE_LOCAL_ROOT", "uploads"),
+		S3Endpoint:  db.GetEnv("S3_ENDPOINT", ""),
+		S3Region:    db.GetEnv("S3_REGION", "us-east-1"),
+		S3Bucket:    db.GetEnv("S3_BUCKET", ""),
+		S3AccessKey: db.GetEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey: db.GetEnv("S3_SECRET_KEY", ""),
+		S3PathStyle: db.GetEnv("S3_PATH_STYLE", "true") == "true",
+	})
+	if err != nil {
+		log.Fatal("❌ Failed to init storage: ", err)
+	}
+
+	// Outgoing mail (verification, password reset); "log" just logs them
+	mailer, err := mail.New(mail.Config{
+		Driver:       db.GetEnv("MAIL_DRIVER", "log"),
+		From:         db.GetEnv("MAIL_FROM", "FileVault <noreply@localhost>"),
+		SMTPHost:     db.GetEnv("SMTP_HOST", ""),
+		SMTPPort:     db.GetEnv("SMTP_PORT", "587"),
+		SMTPUsername: db.GetEnv("SMTP_USERNAME", ""),
+		SMTPPassword: db.GetEnv("SMTP_PASSWORD", ""),
+	})
+	if err != nil {
+		log.Fatal("❌ Failed to init mailer: ", err)
+	}
+
 	// Handlers
-	userHandler := &api.UserHandler{DB: pool, Secret: secret}
-	fileHandler := &api.FileHandler{DB: pool, Secret: secret}
+	auth := &api.Auth{DB: pool, Secret: secret}
+	proxies, err := api.ParseTrustedProxies(db.GetEnv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		log.Fatal("❌ Invalid TRUSTED_PROXIES: ", err)
+	}
+	auditor := &api.Auditor{DB: pool, Proxies: proxies, Anchor: db.GetEnv("AUDIT_ANCHOR_FILE", "")}
+	accessTTL, err := time.ParseDuration(db.GetEnv("ACCESS_TOKEN_TTL", "15m"))
+	if err != nil {
+		log.Fatal("❌ Invalid ACCESS_TOKEN_TTL: ", err)
+	}
+	refreshTTL, err := time.ParseDuration(db.GetEnv("REFRESH_TOKEN_TTL", "720h"))
+	if err != nil {
+		log.Fatal("❌ Invalid REFRESH_TOKEN_TTL: ", err)
+	}
+	// Rate limits per route group, each applied per client IP and per account
+	limit := func(env, def string) api.RateLimit {
+		l, err := api.ParseRateLimit(db.GetEnv(env, def))
+		if err != nil {
+			log.Fatal("❌ Invalid "+env+": ", err)
+		}
+		return l
+	}
+	authLimits := api.NewRouteLimits(limit("RATE_LIMIT_AUTH", "20/1m"), proxies)
+	uploadLimits := api.NewRouteLimits(limit("RATE_LIMIT_UPLOAD", "60/1m"), proxies)
+	downloadLimits := api.NewRouteLimits(limit("RATE_LIMIT_DOWNLOAD", "300/1m"), proxies)
+	lockoutThreshold, err := strconv.Atoi(db.GetEnv("LOCKOUT_THRESHOLD", "5"))
+	if err != nil || lockoutThreshold < 0 {
+		log.Fatalf("❌ Invalid LOCKOUT_THRESHOLD: %q", db.GetEnv("LOCKOUT_THRESHOLD", "5"))
+	}
+
+	breached, err := api.LoadBreachedList(db.GetEnv("BREACHED_PASSWORDS_FILE", ""))
+	if err != nil {
+		log.Fatal("❌ Failed to load BREACHED_PASSWORDS_FILE: ", err)
+	}
+
+	userHandler := &api.UserHandler{
+		DB:         pool,
+		Secret:     secret,
+		Audit:      auditor,
+		Proxies:    proxies,
+		AccessTTL:  accessTTL,
+		RefreshTTL: refreshTTL,
+		MFAIssuer:  db.GetEnv("MFA_ISSUER", "FileVault"),
+		Mailer:     mailer,
+		AppURL:     db.GetEnv("APP_URL", "http://localhost:5173"),
+
+		LoginLimit:       api.NewLimiter(limit("RATE_LIMIT_LOGIN_ACCOUNT", "10/15m")),
+		LockoutThreshold: lockoutThreshold,
+
+		Breached: breached,
+	}
+	userHandler.StartPurger(context.Background(), time.Hour)
+	maxUpload, err := strconv.ParseInt(db.GetEnv("MAX_UPLOAD_BYTES", "0"), 10, 64)
+	if err != nil || maxUpload < 0 {
+		log.Fatalf("❌ Invalid MAX_UPLOAD_BYTES: %q", db.GetEnv("MAX_UPLOAD_BYTES", "0"))
+	}
+	trashRetention, err := time.ParseDuration(db.GetEnv("TRASH_RETENTION", "720h"))
+	if err != nil {
+		log.Fatal("❌ Invalid TRASH_RETENTION: ", err)
+	}
+	versionKeep, err := strconv.Atoi(db.GetEnv("VERSION_KEEP", "0"))
+	if err != nil || versionKeep < 0 {
+		log.Fatalf("❌ Invalid VERSION_KEEP: %q", db.GetEnv("VERSION_KEEP", "0"))
+	}
+	versionMaxAge, err := time.ParseDuration(db.GetEnv("VERSION_MAX_AGE", "0s"))
+	if err != nil {
+		log.Fatal("❌ Invalid VERSION_MAX_AGE: ", err)
+	}
+	fileHandler := &api.FileHandler{
+		DB:            pool,
+		Secret:        secret,
+		Store:         store,
+		MaxUploadSize: maxUpload,
+		TempDir:       db.GetEnv("UPLOAD_TMP_DIR", ""),
+		MIMEPolicy:    db.GetEnv("MIME_POLICY", api.MIMEPolicyWarn),
+
+		TrashRetention:   trashRetention,
+		TrashCountsQuota: db.GetEnv("TRASH_COUNTS_QUOTA", "true") == "true",
+
+		VersionKeep:   versionKeep,
+		VersionMaxAge: versionMaxAge,
+
+		Proxies:              proxies,
+		RequireVerifiedEmail: db.GetEnv("REQUIRE_VERIFIED_EMAIL", "false") == "true",
+		Audit:                auditor,
+	}
+	fileHandler.StartPurger(context.Background(), time.Hour)
 	shareHandler := &api.ShareHandler{DB: pool, Secret: secret} // ✅ now used
+	groupHandler := &api.GroupHandler{DB: pool, Audit: auditor}
+	apiKeyHandler := &api.APIKeyHandler{DB: pool, Audit: auditor}
+	var oidcHandler *api.OIDCHandler
+	if issuer := db.GetEnv("OIDC_ISSUER", ""); issuer != "" {
+		oidcHandler = &api.OIDCHandler{
+			DB: pool,
+			Provider: &api.OIDCProvider{
+				Issuer:       issuer,
+				ClientID:     db.GetEnv("OIDC_CLIENT_ID", ""),
+				ClientSecret: db.GetEnv("OIDC_CLIENT_SECRET", ""),
+				RedirectURL:  db.GetEnv("OIDC_REDIRECT_URL", "http://localhost:8080/oidc/callback"),
+				Client:       &http.Client{Timeout: 10 * time.Second},
+			},
+			Users: userHandler,
+			Audit: auditor,
+		}
+	}
+	adminHandler := &api.AdminHandler{DB: pool, Files: fileHandler, Audit: auditor}
+
+	// Resumable uploads (tus 1.0)
+	tusExpiry, err := time.ParseDuration(db.GetEnv("TUS_EXPIRY", "24h"))
+	if err != nil {
+		log.Fatal("❌ Invalid TUS_EXPIRY: ", err)
+	}
+	tusHandler := &api.TusHandler{
+		DB:     pool,
+		Files:  fileHandler,
+		Dir:    db.GetEnv("TUS_DIR", "tus-uploads"),
+		Expiry: tusExpiry,
+	}
+	tusHandler.StartPurger(context.Background(), time.Hour)
 
 	// Router
 	r := mux.NewRouter()
 
 	// Public routes
-	r.HandleFunc("/register", userHandler.Register).Methods("POST")
-	r.HandleFunc("/login", userHandler.Login).Methods("POST")
+	r.Handle("/register", authLimits.Wrap(http.HandlerFunc(userHandler.Register))).Methods("POST")
+	r.Handle("/login", authLimits.Wrap(http.HandlerFunc(userHandler.Login))).Methods("POST")
+	r.Handle("/login/mfa", authLimits.Wrap(http.HandlerFunc(userHandler.LoginMFA))).Methods("POST")
+	if oidcHandler != nil {
+		r.Handle("/oidc/login", authLimits.Wrap(http.HandlerFunc(oidcHandler.Login))).Methods("GET")
+		r.Handle("/oidc/callback", authLimits.Wrap(http.HandlerFunc(oidcHandler.Callback))).Methods("GET", "POST")
+	}
+	r.Handle("/token/refresh", authLimits.Wrap(http.HandlerFunc(userHandler.Refresh))).Methods("POST")
+	r.Handle("/email/verify", authLimits.Wrap(http.HandlerFunc(userHandler.VerifyEmail))).Methods("POST")
+	r.Handle("/password/forgot", authLimits.Wrap(http.HandlerFunc(userHandler.ForgotPassword))).Methods("POST")
+	r.Handle("/password/reset", authLimits.Wrap(http.HandlerFunc(userHandler.ResetPassword))).Methods("POST")
+	r.Handle("/email/verify/resend", auth.Middleware(http.HandlerFunc(userHandler.ResendVerification))).Methods("POST")
+	r.Handle("/logout", auth.Middleware(http.HandlerFunc(userHandler.Logout))).Methods("POST")
+	r.Handle("/logout/all", auth.Middleware(http.HandlerFunc(userHandler.LogoutAll))).Methods("POST")
+
+	// Protected routes; those that take API keys name the scope needed
+	r.Handle("/files", auth.Scoped(api.ScopeFilesWrite, uploadLimits.Wrap(http.HandlerFunc(fileHandler.UploadFile)))).Methods("POST")
+	r.Handle("/files", auth.Scoped(api.ScopeFilesRead, http.HandlerFunc(fileHandler.GetFiles))).Methods("GET")
+	r.Handle("/files/{id}", auth.Scoped(api.ScopeFilesRead, downloadLimits.Wrap(http.HandlerFunc(fileHandler.DownloadFile)))).Methods("GET", "HEAD")
+	r.Handle("/files/{id}", auth.Scoped(api.ScopeFilesWrite, http.HandlerFunc(fileHandler.DeleteFile))).Methods("DELETE")
+	r.Handle("/files/{id}", auth.Scoped(api.ScopeFilesWrite, http.HandlerFunc(fileHandler.RenameFile))).Methods("PATCH")
+
+	r.Handle("/files/{id}/downloads", auth.Scoped(api.ScopeFilesRead, http.HandlerFunc(fileHandler.GetDownloads))).Methods("GET")
+
+	r.Handle("/files/{id}/versions", auth.Scoped(api.ScopeFilesWrite, uploadLimits.Wrap(http.HandlerFunc(fileHandler.UploadVersion)))).Methods("POST")
+	r.Handle("/files/{id}/versions", auth.Scoped(api.ScopeFilesRead, http.HandlerFunc(fileHandler.GetVersions))).Methods("GET")
+	r.Handle("/files/{id}/versions/{version}", auth.Scoped(api.ScopeFilesRead, downloadLimits.Wrap(http.HandlerFunc(fileHandler.DownloadVersion)))).Methods("GET", "HEAD")
+	r.Handle("/files/{id}/versions/{version}", auth.Scoped(api.ScopeFilesWrite, http.HandlerFunc(fileHandler.DeleteVersion))).Methods("DELETE")
+	r.Handle("/files/{id}/versions/{version}/promote", auth.Scoped(api.ScopeFilesWrite, http.HandlerFunc(fileHandler.PromoteVersion))).Methods("POST")
+
+	r.Handle("/folders", auth.Scoped(api.ScopeFilesWrite, http.HandlerFunc(fileHandler.CreateFolder))).Methods("POST")
+	r.Handle("/folders", auth.Scoped(api.ScopeFilesRead, http.HandlerFunc(fileHandler.GetFolder))).Methods("GET")
+	r.Handle("/folders/{id}", auth.Scoped(api.ScopeFilesRead, http.HandlerFunc(fileHandler.GetFolder))).Methods("GET")
+	r.Handle("/folders/{id}", auth.Scoped(api.ScopeFilesWrite, http.HandlerFunc(fileHandler.UpdateFolder))).Methods("PATCH")
+	r.Handle("/folders/{id}", auth.Scoped(api.ScopeFilesWrite, http.HandlerFunc(fileHandler.DeleteFolder))).Methods("DELETE")
+
+	r.Handle("/trash", auth.Scoped(api.ScopeFilesRead, http.HandlerFunc(fileHandler.GetTrash))).Methods("GET")
+	r.Handle("/trash/{id}/restore", auth.Scoped(api.ScopeFilesWrite, http.HandlerFunc(fileHandler.RestoreFile))).Methods("POST")
+	r.Handle("/trash/{id}", auth.Scoped(api.ScopeFilesWrite, http.HandlerFunc(fileHandler.PurgeFile))).Methods("DELETE")
+
+	r.Handle("/uploads", auth.Scoped(api.ScopeFilesWrite, uploadLimits.Wrap(http.HandlerFunc(tusHandler.Create)))).Methods("POST")
+	r.Handle("/uploads/{id}", auth.Scoped(api.ScopeFilesWrite, http.HandlerFunc(tusHandler.Head))).Methods("HEAD")
+	r.Handle("/uploads/{id}", auth.Scoped(api.ScopeFilesWrite, uploadLimits.Wrap(http.HandlerFunc(tusHandler.Patch)))).Methods("PATCH")
+	r.Handle("/uploads/{id}", auth.Scoped(api.ScopeFilesWrite, http.HandlerFunc(tusHandler.Terminate))).Methods("DELETE")
+
+	r.Handle("/files/{id}/links", auth.Scoped(api.ScopeSharesManage, http.HandlerFunc(fileHandler.CreateLink))).Methods("POST")
+	r.Handle("/files/{id}/links", auth.Scoped(api.ScopeSharesManage, http.HandlerFunc(fileHandler.GetLinks))).Methods("GET")
+	r.Handle("/files/{id}/links/{link_id}", auth.Scoped(api.ScopeSharesManage, http.HandlerFunc(fileHandler.RevokeLink))).Methods("DELETE")
+	// Public links need no account
+	r.Handle("/s/{token}", downloadLimits.Wrap(http.HandlerFunc(fileHandler.PublicDownload))).Methods("GET", "HEAD", "POST")
+
+	r.Handle("/share", auth.Scoped(api.ScopeSharesManage, http.HandlerFunc(fileHandler.ShareFile))).Methods("POST")
+	r.Handle("/shared", auth.Scoped(api.ScopeFilesRead, http.HandlerFunc(fileHandler.GetSharedFiles))).Methods("GET")
+	r.Handle("/files/{id}/shares", auth.Scoped(api.ScopeSharesManage, http.HandlerFunc(fileHandler.GetFileShares))).Methods("GET")
+	r.Handle("/shares/{id}", auth.Scoped(api.ScopeSharesManage, http.HandlerFunc(fileHandler.UpdateShare))).Methods("PATCH")
+	r.Handle("/shares/{id}", auth.Scoped(api.ScopeSharesManage, http.HandlerFunc(fileHandler.RevokeShare))).Methods("DELETE")
+	r.Handle("/files/{id}/invites", auth.Scoped(api.ScopeSharesManage, http.HandlerFunc(fileHandler.GetInvites))).Methods("GET")
+	r.Handle("/files/{id}/invites/{invite_id}", auth.Scoped(api.ScopeSharesManage, http.HandlerFunc(fileHandler.RevokeInvite))).Methods("DELETE")
+
+	r.Handle("/groups", auth.Middleware(http.HandlerFunc(groupHandler.CreateGroup))).Methods("POST")
+	r.Handle("/groups", auth.Middleware(http.HandlerFunc(groupHandler.GetGroups))).Methods("GET")
+	r.Handle("/groups/{id}", auth.Middleware(http.HandlerFunc(groupHandler.GetGroup))).Methods("GET")
+	r.Handle("/groups/{id}", auth.Middleware(http.HandlerFunc(groupHandler.DeleteGroup))).Methods("DELETE")
+	r.Handle("/groups/{id}/members", auth.Middleware(http.HandlerFunc(groupHandler.AddMember))).Methods("POST")
+	r.Handle("/groups/{id}/members/{user_id}", auth.Middleware(http.HandlerFunc(groupHandler.UpdateMember))).Methods("PATCH")
+	r.Handle("/groups/{id}/members/{user_id}", auth.Middleware(http.HandlerFunc(groupHandler.RemoveMember))).Methods("DELETE")
+
+	r.Handle("/apikeys", auth.Middleware(http.HandlerFunc(apiKeyHandler.CreateKey))).Methods("POST")
+	r.Handle("/apikeys", auth.Middleware(http.HandlerFunc(apiKeyHandler.GetKeys))).Methods("GET")
+	r.Handle("/apikeys/{id}", auth.Middleware(http.HandlerFunc(apiKeyHandler.RevokeKey))).Methods("DELETE")
 
-	// Protected routes
-	r.Handle("/files", api.AuthMiddleware(http.HandlerFunc(fileHandler.UploadFile), secret)).Methods("POST")
-	r.Handle("/files", api.AuthMiddleware(http.HandlerFunc(fileHandler.GetFiles), secret)).Methods("GET")
-	r.Handle("/files/{id}", api.AuthMiddleware(http.HandlerFunc(fileHandler.DownloadFile), secret)).Methods("GET")
-	r.Handle("/files/{id}", api.AuthMiddleware(http.HandlerFunc(fileHandler.DeleteFile), secret)).Methods("DELETE")
+	r.Handle("/mfa", auth.Middleware(http.HandlerFunc(userHandler.GetMFA))).Methods("GET")
+	r.Handle("/mfa/enroll", auth.Middleware(http.HandlerFunc(userHandler.EnrollMFA))).Methods("POST")
+	r.Handle("/mfa/confirm", auth.Middleware(http.HandlerFunc(userHandler.ConfirmMFA))).Methods("POST")
+	r.Handle("/mfa/disable", auth.Middleware(http.HandlerFunc(userHandler.DisableMFA))).Methods("POST")
+	r.Handle("/mfa/recovery-codes", auth.Middleware(http.HandlerFunc(userHandler.RegenerateRecoveryCodes))).Methods("POST")
 
-	r.Handle("/share", api.AuthMiddleware(http.HandlerFunc(fileHandler.ShareFile), secret)).Methods("POST")
-	r.Handle("/shared", api.AuthMiddleware(http.HandlerFunc(fileHandler.GetSharedFiles), secret)).Methods("GET")
+	r.Handle("/storage", auth.Scoped(api.ScopeFilesRead, http.HandlerFunc(fileHandler.GetStorage))).Methods("GET")
 
-	r.Handle("/storage", api.AuthMiddleware(http.HandlerFunc(fileHandler.GetStorage), secret)).Methods("GET")
+	// Admin routes (users.role = 'admin')
+	r.Handle("/admin/users", auth.Admin(http.HandlerFunc(adminHandler.ListUsers))).Methods("GET")
+	r.Handle("/admin/users/{id}/storage", auth.Admin(http.HandlerFunc(adminHandler.GetUserStorage))).Methods("GET")
+	r.Handle("/admin/users/{id}/quota", auth.Admin(http.HandlerFunc(adminHandler.SetQuota))).Methods("PATCH")
+	r.Handle("/admin/users/{id}/disable", auth.Admin(http.HandlerFunc(adminHandler.DisableUser))).Methods("POST")
+	r.Handle("/admin/users/{id}/enable", auth.Admin(http.HandlerFunc(adminHandler.EnableUser))).Methods("POST")
+	r.Handle("/admin/users/{id}/mfa/reset", auth.Admin(http.HandlerFunc(adminHandler.ResetMFA))).Methods("POST")
+	r.Handle("/admin/files", auth.Admin(http.HandlerFunc(adminHandler.ListFiles))).Methods("GET")
+	r.Handle("/admin/files/{id}", auth.Admin(http.HandlerFunc(adminHandler.DeleteFile))).Methods("DELETE")
+	r.Handle("/admin/audit", auth.Admin(http.HandlerFunc(adminHandler.GetAudit))).Methods("GET")
+	r.Handle("/admin/audit/verify", auth.Admin(http.HandlerFunc(adminHandler.VerifyAudit))).Methods("GET")
 
 	// Optional: routes using ShareHandler if you extend functionality
 	_ = shareHandler // avoids unused error if not yet wired
 
 	// CORS
-	headers := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
-	methods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"})
+	headers := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization",
+		"Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset",
+		"Range", "If-None-Match", "If-Modified-Since", "If-Range", "X-Link-Password", "X-API-Key"})
+	methods := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"})
 	origins := handlers.AllowedOrigins([]string{"*"})
+	exposed := handlers.ExposedHeaders([]string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension",
+		"Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Expires", "X-File-ID", "Retry-After",
+		"ETag", "Content-Range", "Content-Disposition", "Accept-Ranges", "Last-Modified"})
 
 	log.Println("🚀 Server started at :8080")
-	log.Fatal(http.ListenAndServe(":8080", handlers.CORS(headers, methods, origins)(r)))
+	log.Fatal(http.ListenAndServe(":8080", api.TusDiscovery(handlers.CORS(headers, methods, origins, exposed)(r), tusHandler)))
 }
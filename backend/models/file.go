@@ -5,11 +5,17 @@ import "time"
 type File struct {
 	ID         int       `json:"id"`
 	UserID     int       `json:"user_id"`
+	FolderID   *int      `json:"folder_id"`
 	Filename   string    `json:"filename"`
 	Filepath   string    `json:"filepath"`
 	FileHash   string    `json:"file_hash"`
 	RefCount   int       `json:"ref_count"`
+	Size       int64     `json:"size"`
+	MimeType   string    `json:"mime_type"`
 	UploadedAt time.Time `json:"uploaded_at"`
+
+	// Only filled in for the owner's own listings
+	DownloadCount *int `json:"download_count,omitempty"`
 }
 type SharedFile struct {
 	File
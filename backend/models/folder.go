@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+type Folder struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	ParentID  *int      `json:"parent_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FolderListing is the content of one folder (or the root)
+type FolderListing struct {
+	Folder  *Folder  `json:"folder"`
+	Folders []Folder `json:"folders"`
+	Files   []File   `json:"files"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Group is a named set of users that files can be shared with
+type Group struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy int       `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	IsAdmin   bool      `json:"is_admin"`
+	Members   int       `json:"member_count"`
+}
+
+// GroupMember is one user's membership in a group
+type GroupMember struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	IsAdmin  bool      `json:"is_admin"`
+	AddedAt  time.Time `json:"added_at"`
+}
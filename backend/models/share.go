@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Share is one grant of a file to another user or a group, as seen by its
+// owner. Exactly one of TargetUser and TargetGroup is set.
+type Share struct {
+	ID          int       `json:"id"`
+	FileID      int       `json:"file_id"`
+	SharedBy    int       `json:"shared_by"`
+	TargetUser  *int      `json:"target_user"`
+	TargetName  string    `json:"target_username,omitempty"`
+	TargetEmail string    `json:"target_email,omitempty"`
+	TargetGroup *int      `json:"target_group"`
+	GroupName   string    `json:"group_name,omitempty"`
+	ShareType   string    `json:"share_type"`
+	SharedAt    time.Time `json:"shared_at"`
+}
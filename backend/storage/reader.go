@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// RangeGetter is implemented by backends that can fetch a byte range
+// without transferring the whole object.
+type RangeGetter interface {
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Open returns a seekable reader over key, suitable for http.ServeContent.
+// Backends that already return seekable readers (local files) are used
+// directly; others are re-opened at the requested offset on demand.
+func Open(ctx context.Context, b Backend, key string, size int64) (io.ReadSeekCloser, error) {
+	rc, err := b.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if rsc, ok := rc.(io.ReadSeekCloser); ok {
+		return rsc, nil
+	}
+	return &seeker{ctx: ctx, b: b, key: key, size: size, rc: rc}, nil
+}
+
+// seeker emulates io.Seeker by reopening the object at the new position
+type seeker struct {
+	ctx   context.Context
+	b     Backend
+	key   string
+	size  int64
+	pos   int64 // logical position
+	rc    io.ReadCloser
+	rcPos int64 // position of rc
+}
+
+func (s *seeker) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	if s.rc == nil || s.rcPos != s.pos {
+		if err := s.reopen(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.rc.Read(p)
+	s.pos += int64(n)
+	s.rcPos = s.pos
+	return n, err
+}
+
+func (s *seeker) reopen() error {
+	if s.rc != nil {
+		s.rc.Close()
+		s.rc = nil
+	}
+	if rg, ok := s.b.(RangeGetter); ok {
+		rc, err := rg.GetRange(s.ctx, s.key, s.pos, s.size-s.pos)
+		if err != nil {
+			return err
+		}
+		s.rc, s.rcPos = rc, s.pos
+		return nil
+	}
+
+	// No range support: read from the start and skip ahead
+	rc, err := s.b.Get(s.ctx, s.key)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(io.Discard, rc, s.pos); err != nil {
+		rc.Close()
+		return err
+	}
+	s.rc, s.rcPos = rc, s.pos
+	return nil
+}
+
+func (s *seeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *seeker) Close() error {
+	if s.rc == nil {
+		return nil
+	}
+	return s.rc.Close()
+}
@@ -0,0 +1,457 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion    = "eu-central-1"
+	testBucket    = "vault"
+)
+
+// fakeS3 is a minimal in-memory S3 stand-in. It checks every request's
+// SigV4 signature on its own, from the request as received, and answers
+// with S3-style XML errors.
+type fakeS3 struct {
+	t         *testing.T
+	pathStyle bool
+	pageSize  int
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	fail    int // answer the next request with this status, if set
+}
+
+func newFakeS3(t *testing.T, pathStyle bool) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{t: t, pathStyle: pathStyle, pageSize: 1000, objects: map[string][]byte{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, msg)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fail != 0 {
+		status := f.fail
+		f.fail = 0
+		http.Error(w, "upstream trouble", status)
+		return
+	}
+	if code, msg := f.verify(r); code != "" {
+		writeS3Error(w, http.StatusForbidden, code, msg)
+		return
+	}
+
+	key := r.URL.Path
+	if f.pathStyle {
+		var ok bool
+		if key, ok = strings.CutPrefix(key, "/"+testBucket); !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "no such bucket")
+			return
+		}
+	} else if host, _, _ := strings.Cut(r.Host, "."); host != testBucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "no such bucket")
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r)
+	case r.Method == http.MethodPut:
+		if r.ContentLength < 0 {
+			writeS3Error(w, http.StatusLengthRequired, "MissingContentLength", "length required")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", "no such key")
+			return
+		}
+		w.Header().Set("Last-Modified", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "want list-type=2")
+		return
+	}
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, q.Get("prefix")) && k > q.Get("continuation-token") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var res listBucketResult
+	if len(keys) > f.pageSize {
+		keys = keys[:f.pageSize]
+		res.IsTruncated = true
+		res.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, k := range keys {
+		res.Contents = append(res.Contents, struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		}{k, int64(len(f.objects[k])), time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listBucketResult
+	}{listBucketResult: res})
+}
+
+// verify recomputes the request's SigV4 signature with testSecretKey
+func (f *fakeS3) verify(r *http.Request) (code, msg string) {
+	auth := r.Header.Get("Authorization")
+	rest, ok := strings.CutPrefix(auth, "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return "AccessDenied", "missing or unsupported Authorization"
+	}
+	fields := map[string]string{}
+	for _, kv := range strings.Split(rest, ", ") {
+		k, v, _ := strings.Cut(kv, "=")
+		fields[k] = v
+	}
+	cred := strings.Split(fields["Credential"], "/")
+	if len(cred) != 5 || cred[0] != testAccessKey || cred[2] != testRegion || cred[3] != "s3" || cred[4] != "aws4_request" {
+		return "InvalidAccessKeyId", "bad credential scope " + fields["Credential"]
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if !strings.HasPrefix(amzDate, cred[1]) {
+		return "AuthorizationHeaderMalformed", "date does not match scope"
+	}
+	if r.Header.Get("X-Amz-Content-Sha256") != "UNSIGNED-PAYLOAD" {
+		return "InvalidRequest", "expected UNSIGNED-PAYLOAD"
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	var hdrs strings.Builder
+	for _, name := range signed {
+		v := r.Header.Get(name)
+		if name == "host" {
+			v = r.Host
+		}
+		hdrs.WriteString(name + ":" + strings.TrimSpace(v) + "\n")
+	}
+	if !sort.StringsAreSorted(signed) || !strings.Contains(fields["SignedHeaders"], "host") {
+		return "AuthorizationHeaderMalformed", "signed headers must be sorted and include host"
+	}
+
+	// Canonical query: sorted, RFC 3986 encoded
+	q := r.URL.Query()
+	var params []string
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	sort.Strings(params)
+
+	canonical := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(params, "&"),
+		hdrs.String(),
+		fields["SignedHeaders"],
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	sum := sha256.Sum256([]byte(canonical))
+	scope := strings.Join(cred[1:], "/")
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	mac := func(key []byte, s string) []byte {
+		m := hmac.New(sha256.New, key)
+		m.Write([]byte(s))
+		return m.Sum(nil)
+	}
+	k := mac([]byte("AWS4"+testSecretKey), cred[1])
+	k = mac(k, cred[2])
+	k = mac(k, cred[3])
+	k = mac(k, cred[4])
+	if want := hex.EncodeToString(mac(k, toSign)); !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+		return "SignatureDoesNotMatch", "signature mismatch"
+	}
+	return "", ""
+}
+
+func uriEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func newTestS3(t *testing.T, endpoint string, pathStyle bool, secret string, client *http.Client) *S3 {
+	t.Helper()
+	s, err := NewS3(S3Options{
+		Endpoint:  endpoint,
+		Region:    testRegion,
+		Bucket:    testBucket,
+		AccessKey: testAccessKey,
+		SecretKey: secret,
+		PathStyle: pathStyle,
+		Client:    client,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC) }
+	return s
+}
+
+func readAll(t *testing.T, rc io.ReadCloser, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	fake, srv := newFakeS3(t, true)
+	s := newTestS3(t, srv.URL, true, testSecretKey, nil)
+	ctx := context.Background()
+
+	// Keys with characters SigV4 must percent-encode
+	keys := map[string]string{
+		"ab/cd/abcdef":             "hello, vault",
+		"dir/with space+plus=eq~":  "odd key",
+		"unicode/ünïcødé/ファイル.txt": "multi-byte",
+	}
+	for key, body := range keys {
+		if err := s.Put(ctx, key, strings.NewReader(body), int64(len(body))); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+		if got := string(fake.objects[key]); got != body {
+			t.Fatalf("stored %q under %q, want %q", got, key, body)
+		}
+		rc, err := s.Get(ctx, key)
+		if got := readAll(t, rc, err); got != body {
+			t.Fatalf("Get(%q) = %q, want %q", key, got, body)
+		}
+		info, err := s.Stat(ctx, key)
+		if err != nil {
+			t.Fatalf("Stat(%q): %v", key, err)
+		}
+		if info.Size != int64(len(body)) || info.ModTime.IsZero() {
+			t.Fatalf("Stat(%q) = %+v", key, info)
+		}
+	}
+
+	rc, err := s.GetRange(ctx, "ab/cd/abcdef", 7, 5)
+	if got := readAll(t, rc, err); got != "vault" {
+		t.Fatalf("GetRange = %q, want %q", got, "vault")
+	}
+
+	for key := range keys {
+		if err := s.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete(%q): %v", key, err)
+		}
+		if _, err := s.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Stat after Delete(%q) = %v, want ErrNotFound", key, err)
+		}
+	}
+	if len(fake.objects) != 0 {
+		t.Fatalf("objects left behind: %v", fake.objects)
+	}
+}
+
+func TestS3PutUnknownSize(t *testing.T) {
+	fake, srv := newFakeS3(t, true)
+	s := newTestS3(t, srv.URL, true, testSecretKey, nil)
+
+	body := strings.Repeat("x", 64<<10)
+	// Hide the length so Put has to spool
+	if err := s.Put(context.Background(), "big", io.MultiReader(strings.NewReader(body)), -1); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.objects["big"]) != len(body) {
+		t.Fatalf("stored %d bytes, want %d", len(fake.objects["big"]), len(body))
+	}
+
+	if err := s.Put(context.Background(), "empty", strings.NewReader(""), 0); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := fake.objects["empty"]; !ok || len(data) != 0 {
+		t.Fatalf("empty object = %q, %v", data, ok)
+	}
+}
+
+func TestS3List(t *testing.T) {
+	fake, srv := newFakeS3(t, true)
+	fake.pageSize = 2 // force continuation tokens
+	s := newTestS3(t, srv.URL, true, testSecretKey, nil)
+	ctx := context.Background()
+
+	want := []string{"aa/01", "aa/02", "aa/03", "aa/04", "aa/05"}
+	for _, k := range append(want, "bb/01") {
+		if err := s.Put(ctx, k, strings.NewReader(k), int64(len(k))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	err := s.List(ctx, "aa/", func(info ObjectInfo) error {
+		if info.Size != int64(len(info.Key)) {
+			t.Errorf("%s: size %d", info.Key, info.Size)
+		}
+		got = append(got, info.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("List = %v, want %v", got, want)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = s.List(ctx, "", func(ObjectInfo) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Fatalf("List stopped with %v after %d objects, want stop after 1", err, n)
+	}
+}
+
+func TestS3VirtualHostStyle(t *testing.T) {
+	fake, srv := newFakeS3(t, false)
+	// Resolve vault.s3.test to the stand-in
+	addr := srv.Listener.Addr().String()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	_, port, _ := net.SplitHostPort(addr)
+	s := newTestS3(t, "http://s3.test:"+port, false, testSecretKey, client)
+
+	if err := s.Put(context.Background(), "ab/cd/key", strings.NewReader("v"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.objects["ab/cd/key"]) != "v" {
+		t.Fatalf("objects = %v", fake.objects)
+	}
+}
+
+func TestS3Errors(t *testing.T) {
+	fake, srv := newFakeS3(t, true)
+	s := newTestS3(t, srv.URL, true, testSecretKey, nil)
+	ctx := context.Background()
+
+	if _, err := s.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get missing = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetRange(ctx, "missing", 0, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRange missing = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Stat(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat missing = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, "missing"); err != nil {
+		t.Errorf("Delete missing = %v, want nil", err)
+	}
+
+	// A wrong secret is refused with S3's error code passed through
+	bad := newTestS3(t, srv.URL, true, "not-the-secret", nil)
+	err := bad.Put(ctx, "k", strings.NewReader("v"), 1)
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("Put with wrong secret = %v, want SignatureDoesNotMatch", err)
+	}
+	if _, ok := fake.objects["k"]; ok {
+		t.Error("unsigned Put was stored")
+	}
+	if err := bad.List(ctx, "", func(ObjectInfo) error { return nil }); err == nil ||
+		!strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("List with wrong secret = %v, want SignatureDoesNotMatch", err)
+	}
+
+	// Errors without an XML body fall back to the status
+	for _, status := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		fake.fail = status
+		err := s.Delete(ctx, "k")
+		if err == nil || !strings.Contains(err.Error(), strconv.Itoa(status)) {
+			t.Errorf("Delete on %d = %v", status, err)
+		}
+	}
+
+	// Bad endpoints are caught up front
+	if _, err := NewS3(S3Options{Bucket: testBucket}); err == nil {
+		t.Error("NewS3 without endpoint succeeded")
+	}
+	if _, err := NewS3(S3Options{Endpoint: "http://%zz", Bucket: testBucket}); err == nil {
+		t.Error("NewS3 with bad endpoint succeeded")
+	}
+}
+
+func TestS3SignsFixedClock(t *testing.T) {
+	s := newTestS3(t, "http://localhost:9000", true, testSecretKey, nil)
+	req, _ := http.NewRequest(http.MethodGet, s.objectURL("ab/cd/a b", nil).String(), nil)
+	s.sign(req)
+
+	if got := req.Header.Get("X-Amz-Date"); got != "20240501T123000Z" {
+		t.Errorf("X-Amz-Date = %q", got)
+	}
+	if got := req.URL.EscapedPath(); got != "/vault/ab/cd/a%20b" {
+		t.Errorf("path = %q", got)
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential="+testAccessKey+"/20240501/"+testRegion+"/s3/aws4_request, "+
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=") {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	// Same request, same clock, same signature
+	again, _ := http.NewRequest(http.MethodGet, req.URL.String(), nil)
+	s.sign(again)
+	if again.Header.Get("Authorization") != auth {
+		t.Error("signature is not deterministic")
+	}
+}
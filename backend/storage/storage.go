@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNotFound is returned when a key does not exist in the backend
+var ErrNotFound = errors.New("storage: object not found")
+
+// ObjectInfo describes a stored blob
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend is the blob store used by the file handlers.
+// Keys are slash separated relative paths (e.g. "ab/cd/<sha256>").
+type Backend interface {
+	// Put streams r into key. size may be -1 when unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens key for reading. The caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Stat returns metadata for key or ErrNotFound.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every object under prefix, stopping at the first error.
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+}
+
+// Config selects and configures a Backend
+type Config struct {
+	Driver string // "local" (default) or "s3"
+
+	// local driver
+	LocalRoot string
+
+	// s3 driver (AWS S3, MinIO, ...)
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3PathStyle bool
+}
+
+// New builds the backend described by cfg
+func New(cfg Config) (Backend, error) {
+	switch cfg.Driver {
+	case "", "local":
+		root := cfg.LocalRoot
+		if root == "" {
+			root = "uploads"
+		}
+		return NewLocal(root)
+	case "s3":
+		return NewS3(S3Options{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("storage: unknown driver %q", cfg.Driver)
+	}
+}
+
+// HashKey returns the content-addressed key for a hex SHA-256 digest,
+// sharded by its first two bytes: "ab/cd/abcd…".
+func HashKey(hash string) string {
+	if len(hash) < 4 {
+		return hash
+	}
+	return hash[0:2] + "/" + hash[2:4] + "/" + hash
+}
+
+// FilePutter is implemented by backends that can adopt a finished local
+// file directly (e.g. by renaming it) instead of copying its bytes.
+type FilePutter interface {
+	PutFile(ctx context.Context, key, path string) error
+}
+
+// PutFile stores the local file at path under key, moving it into place
+// when the backend supports it and streaming it otherwise.
+func PutFile(ctx context.Context, b Backend, key, path string) error {
+	if fp, ok := b.(FilePutter); ok {
+		return fp.PutFile(ctx, key, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return b.Put(ctx, key, f, fi.Size())
+}
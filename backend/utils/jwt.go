@@ -3,6 +3,7 @@ package utils
 import (
 	"context"
 	"errors"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,6 +12,13 @@ import (
 
 var userIDKey = &struct{}{}
 
+type ctxKey string
+
+const (
+	roleKey   ctxKey = "role"
+	scopesKey ctxKey = "scopes"
+)
+
 // WithUserID puts user_id into context
 func WithUserID(ctx context.Context, userID int) context.Context {
 	return context.WithValue(ctx, userIDKey, userID)
@@ -22,16 +30,68 @@ func GetUserID(ctx context.Context) (int, bool) {
 	return val, ok
 }
 
-// GenerateJWT creates a JWT for a given user
-func GenerateJWT(userID int, email, secret string) (string, error) {
+// WithRole puts the user's role into context
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey, role)
+}
+
+// GetRole retrieves the user's role from context
+func GetRole(ctx context.Context) (string, bool) {
+	val, ok := ctx.Value(roleKey).(string)
+	return val, ok
+}
+
+// WithScopes marks the request as made with an API key limited to scopes
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey, scopes)
+}
+
+// GetScopes retrieves the API key scopes from context; ok is false for
+// requests authenticated with a login session, which are not limited
+func GetScopes(ctx context.Context) ([]string, bool) {
+	val, ok := ctx.Value(scopesKey).([]string)
+	return val, ok
+}
+
+// GenerateJWT creates a JWT for a given user, valid for 24h
+func GenerateJWT(userID int, email, role, secret string) (string, error) {
+	token, _, err := GenerateAccessToken(userID, email, role, secret, 24*time.Hour)
+	return token, err
+}
+
+// IssuedAt is the iat claim for t: Unix seconds to the millisecond, so a
+// token issued earlier in the same second as a "log out everywhere" is
+// still caught by it (see RevokedAt)
+func IssuedAt(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
+
+// RevokedAt is the sessions_revoked_at value for a revocation at t.
+// Tokens with iat at or before it are dead.
+func RevokedAt(t time.Time) time.Time {
+	return t.Truncate(time.Millisecond)
+}
+
+// GenerateAccessToken creates a JWT valid for ttl and returns it with its
+// jti, the id used to revoke it before it expires
+func GenerateAccessToken(userID int, email, role, secret string, ttl time.Duration) (string, string, error) {
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"user_id": userID,
 		"email":   email,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
+		"role":    role,
+		"jti":     jti,
+		"iat":     IssuedAt(now),
+		"exp":     now.Add(ttl).Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	signed, err := token.SignedString([]byte(secret))
+	return signed, jti, err
 }
 
 // ValidateAndGetClaims parses and validates JWT, returns claims
@@ -72,5 +132,44 @@ func ExtractToken(authHeader string) string {
 func GenerateJWTLegacy(userID int, email string) (string, error) {
 	// You’ll need to pass your secret from env or config
 	secret := "supersecret" // TODO: replace with os.Getenv("JWT_SECRET")
-	return GenerateJWT(userID, email, secret)
+	return GenerateJWT(userID, email, "user", secret)
+}
+
+// GenerateChallengeToken creates a short-lived JWT for one step of a
+// multi-step flow (e.g. "mfa" after the password check). It carries the
+// user in "sub" rather than "user_id", so it is never accepted as an
+// access token.
+func GenerateChallengeToken(userID int, purpose, secret string, ttl time.Duration) (string, error) {
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":     strconv.Itoa(userID),
+		"purpose": purpose,
+		"jti":     jti,
+		"iat":     now.Unix(),
+		"exp":     now.Add(ttl).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+// ValidateChallengeToken checks a token from GenerateChallengeToken for
+// purpose and returns the user id and jti
+func ValidateChallengeToken(tokenStr, purpose, secret string) (int, string, error) {
+	claims, err := ValidateAndGetClaims(tokenStr, secret)
+	if err != nil {
+		return 0, "", err
+	}
+	if p, _ := claims["purpose"].(string); p != purpose {
+		return 0, "", errors.New("wrong token purpose")
+	}
+	sub, _ := claims["sub"].(string)
+	userID, err := strconv.Atoi(sub)
+	if err != nil {
+		return 0, "", errors.New("invalid token subject")
+	}
+	jti, _ := claims["jti"].(string)
+	return userID, jti, nil
 }
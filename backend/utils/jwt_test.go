@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessTokenIssuedAtPrecision(t *testing.T) {
+	token, _, err := GenerateAccessToken(7, "a@example.com", "user", "secret", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateAndGetClaims(token, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		t.Fatalf("iat = %#v", claims["iat"])
+	}
+	if d := time.Since(time.UnixMilli(int64(iat * 1000))); d < 0 || d > time.Second {
+		t.Fatalf("iat %v is %v from now", iat, d)
+	}
+}
+
+func TestRevokedAtCoversSameSecond(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	revoked := RevokedAt(base.Add(700*time.Millisecond + 300*time.Microsecond))
+	cutoff := float64(revoked.UnixMicro()) / 1e6 // what to_timestamp compares against
+
+	for _, tc := range []struct {
+		issued time.Duration
+		dead   bool
+	}{
+		{100 * time.Millisecond, true}, // earlier in the same second
+		{700 * time.Millisecond, true}, // the same millisecond
+		{701 * time.Millisecond, false},
+		{1500 * time.Millisecond, false},
+		{-time.Second, true},
+	} {
+		iat := IssuedAt(base.Add(tc.issued))
+		if dead := cutoff >= iat; dead != tc.dead {
+			t.Errorf("token issued at +%v: revoked=%v, want %v", tc.issued, dead, tc.dead)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signed tokens are "<payload>.<mac>", both base64url, where payload is
+// "purpose:user_id:expiry:nonce". The MAC lets forged or expired tokens be
+// rejected without a database lookup; callers still track the token
+// (by HashToken) to make it single-use.
+
+var (
+	ErrTokenInvalid = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+func signPayload(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignedToken creates a token for purpose and userID valid until expires
+func SignedToken(secret, purpose string, userID int, expires time.Time) (string, error) {
+	nonce, err := RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+	payload := purpose + ":" + strconv.Itoa(userID) + ":" + strconv.FormatInt(expires.Unix(), 10) + ":" + nonce
+	enc := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return enc + "." + signPayload(secret, enc), nil
+}
+
+// VerifySignedToken checks token's signature, purpose and expiry at now
+// and returns the user id it was issued for
+func VerifySignedToken(secret, purpose, token string, now time.Time) (int, error) {
+	enc, mac, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(signPayload(secret, enc))) {
+		return 0, ErrTokenInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return 0, ErrTokenInvalid
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 4 || parts[0] != purpose {
+		return 0, ErrTokenInvalid
+	}
+	userID, err1 := strconv.Atoi(parts[1])
+	exp, err2 := strconv.ParseInt(parts[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, ErrTokenInvalid
+	}
+	if now.Unix() >= exp {
+		return 0, ErrTokenExpired
+	}
+	return userID, nil
+}
@@ -19,10 +19,108 @@ SET xmloption = content;
 SET client_min_messages = warning;
 SET row_security = off;
 
+--
+-- Name: audit_log_append_only(); Type: FUNCTION; Schema: public; Owner: postgres
+--
+
+CREATE FUNCTION public.audit_log_append_only() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$;
+
+
+ALTER FUNCTION public.audit_log_append_only() OWNER TO postgres;
+
 SET default_tablespace = '';
 
 SET default_table_access_method = heap;
 
+--
+-- Name: api_keys; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.api_keys (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    name character varying(100) NOT NULL,
+    prefix character varying(16) NOT NULL,
+    key_hash character(64) NOT NULL,
+    scopes text[] NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    expires_at timestamp with time zone,
+    last_used_at timestamp with time zone,
+    revoked_at timestamp with time zone
+);
+
+
+ALTER TABLE public.api_keys OWNER TO postgres;
+
+--
+-- Name: api_keys_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.api_keys_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.api_keys_id_seq OWNER TO postgres;
+
+--
+-- Name: api_keys_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.api_keys_id_seq OWNED BY public.api_keys.id;
+
+
+--
+-- Name: audit_log; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.audit_log (
+    id bigint NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    actor_id integer,
+    action character varying(64) NOT NULL,
+    target_type character varying(32) DEFAULT ''::character varying NOT NULL,
+    target_id character varying(64) DEFAULT ''::character varying NOT NULL,
+    ip character varying(45) DEFAULT ''::character varying NOT NULL,
+    details text DEFAULT '{}'::text NOT NULL,
+    prev_hash character(64) NOT NULL,
+    hash character(64) NOT NULL
+);
+
+
+ALTER TABLE public.audit_log OWNER TO postgres;
+
+--
+-- Name: audit_log_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.audit_log_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.audit_log_id_seq OWNER TO postgres;
+
+--
+-- Name: audit_log_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.audit_log_id_seq OWNED BY public.audit_log.id;
+
+
 --
 -- Name: downloads; Type: TABLE; Schema: public; Owner: postgres
 --
@@ -31,7 +129,15 @@ CREATE TABLE public.downloads (
     id integer NOT NULL,
     file_id integer,
     downloaded_at timestamp without time zone DEFAULT now(),
-    downloader_ip character varying(45)
+    downloader_ip character varying(45),
+    user_id integer,
+    link_id integer,
+    version integer,
+    user_agent text,
+    bytes_served bigint DEFAULT 0 NOT NULL,
+    status smallint DEFAULT 200 NOT NULL,
+    filename character varying(255),
+    owner_id integer
 );
 
 
@@ -59,6 +165,45 @@ ALTER SEQUENCE public.downloads_id_seq OWNER TO postgres;
 ALTER SEQUENCE public.downloads_id_seq OWNED BY public.downloads.id;
 
 
+--
+-- Name: email_tokens; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.email_tokens (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    purpose character varying(20) NOT NULL,
+    token_hash character(64) NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone
+);
+
+
+ALTER TABLE public.email_tokens OWNER TO postgres;
+
+--
+-- Name: email_tokens_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.email_tokens_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.email_tokens_id_seq OWNER TO postgres;
+
+--
+-- Name: email_tokens_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.email_tokens_id_seq OWNED BY public.email_tokens.id;
+
+
 --
 -- Name: file_hashes; Type: TABLE; Schema: public; Owner: postgres
 --
@@ -96,6 +241,47 @@ ALTER SEQUENCE public.file_hashes_id_seq OWNER TO postgres;
 ALTER SEQUENCE public.file_hashes_id_seq OWNED BY public.file_hashes.id;
 
 
+--
+-- Name: file_versions; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.file_versions (
+    id integer NOT NULL,
+    file_id integer NOT NULL,
+    version integer NOT NULL,
+    file_hash_id integer,
+    file_hash text NOT NULL,
+    size bigint NOT NULL,
+    mime_type character varying(100),
+    created_by integer,
+    created_at timestamp without time zone DEFAULT now()
+);
+
+
+ALTER TABLE public.file_versions OWNER TO postgres;
+
+--
+-- Name: file_versions_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.file_versions_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.file_versions_id_seq OWNER TO postgres;
+
+--
+-- Name: file_versions_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.file_versions_id_seq OWNED BY public.file_versions.id;
+
+
 --
 -- Name: files; Type: TABLE; Schema: public; Owner: postgres
 --
@@ -103,15 +289,19 @@ ALTER SEQUENCE public.file_hashes_id_seq OWNED BY public.file_hashes.id;
 CREATE TABLE public.files (
     id integer NOT NULL,
     user_id integer,
+    folder_id integer,
     file_hash_id integer,
     filename character varying(255) NOT NULL,
     mime_type character varying(100),
     uploaded_at timestamp without time zone DEFAULT now(),
-    is_deleted boolean DEFAULT false,
+    is_deleted boolean DEFAULT false NOT NULL,
+    deleted_at timestamp without time zone,
     filepath text,
     file_hash text NOT NULL,
-    ref_count integer DEFAULT 1,
-    size bigint DEFAULT 0
+    size bigint DEFAULT 0,
+    total_size bigint,
+    current_version integer,
+    updated_at timestamp without time zone
 );
 
 
@@ -140,27 +330,25 @@ ALTER SEQUENCE public.files_id_seq OWNED BY public.files.id;
 
 
 --
--- Name: shares; Type: TABLE; Schema: public; Owner: postgres
+-- Name: folders; Type: TABLE; Schema: public; Owner: postgres
 --
 
-CREATE TABLE public.shares (
+CREATE TABLE public.folders (
     id integer NOT NULL,
-    file_id integer,
-    shared_by integer,
-    share_type character varying(20) DEFAULT 'read'::character varying,
-    target_user integer,
-    created_at timestamp without time zone DEFAULT now(),
-    shared_at timestamp without time zone DEFAULT now()
+    user_id integer NOT NULL,
+    parent_id integer,
+    name character varying(255) NOT NULL,
+    created_at timestamp without time zone DEFAULT now()
 );
 
 
-ALTER TABLE public.shares OWNER TO postgres;
+ALTER TABLE public.folders OWNER TO postgres;
 
 --
--- Name: shares_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+-- Name: folders_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
 --
 
-CREATE SEQUENCE public.shares_id_seq
+CREATE SEQUENCE public.folders_id_seq
     AS integer
     START WITH 1
     INCREMENT BY 1
@@ -169,52 +357,48 @@ CREATE SEQUENCE public.shares_id_seq
     CACHE 1;
 
 
-ALTER SEQUENCE public.shares_id_seq OWNER TO postgres;
+ALTER SEQUENCE public.folders_id_seq OWNER TO postgres;
 
 --
--- Name: shares_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+-- Name: folders_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
 --
 
-ALTER SEQUENCE public.shares_id_seq OWNED BY public.shares.id;
+ALTER SEQUENCE public.folders_id_seq OWNED BY public.folders.id;
 
 
 --
--- Name: user_storage; Type: TABLE; Schema: public; Owner: postgres
+-- Name: group_members; Type: TABLE; Schema: public; Owner: postgres
 --
 
-CREATE TABLE public.user_storage (
+CREATE TABLE public.group_members (
+    group_id integer NOT NULL,
     user_id integer NOT NULL,
-    used_space bigint DEFAULT 0,
-    original_space bigint DEFAULT 0,
-    used_bytes bigint DEFAULT 0 NOT NULL,
-    quota_bytes bigint DEFAULT 104857600 NOT NULL
+    is_admin boolean DEFAULT false NOT NULL,
+    added_at timestamp without time zone DEFAULT now() NOT NULL
 );
 
 
-ALTER TABLE public.user_storage OWNER TO postgres;
+ALTER TABLE public.group_members OWNER TO postgres;
 
 --
--- Name: users; Type: TABLE; Schema: public; Owner: postgres
+-- Name: groups; Type: TABLE; Schema: public; Owner: postgres
 --
 
-CREATE TABLE public.users (
+CREATE TABLE public.groups (
     id integer NOT NULL,
-    username character varying(100) NOT NULL,
-    email character varying(255) NOT NULL,
-    password_hash text NOT NULL,
-    role character varying(20) DEFAULT 'user'::character varying,
-    created_at timestamp without time zone DEFAULT now(),
-    used_bytes bigint DEFAULT 0
+    name character varying(100) NOT NULL,
+    created_by integer,
+    created_at timestamp without time zone DEFAULT now() NOT NULL
 );
 
 
-ALTER TABLE public.users OWNER TO postgres;
+ALTER TABLE public.groups OWNER TO postgres;
 
 --
--- Name: users_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+-- Name: groups_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
 --
 
-CREATE SEQUENCE public.users_id_seq
+CREATE SEQUENCE public.groups_id_seq
     AS integer
     START WITH 1
     INCREMENT BY 1
@@ -223,160 +407,910 @@ CREATE SEQUENCE public.users_id_seq
     CACHE 1;
 
 
-ALTER SEQUENCE public.users_id_seq OWNER TO postgres;
+ALTER SEQUENCE public.groups_id_seq OWNER TO postgres;
 
 --
--- Name: users_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+-- Name: groups_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
 --
 
-ALTER SEQUENCE public.users_id_seq OWNED BY public.users.id;
+ALTER SEQUENCE public.groups_id_seq OWNED BY public.groups.id;
 
 
 --
--- Name: downloads id; Type: DEFAULT; Schema: public; Owner: postgres
+-- Name: mfa_recovery_codes; Type: TABLE; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.downloads ALTER COLUMN id SET DEFAULT nextval('public.downloads_id_seq'::regclass);
+CREATE TABLE public.mfa_recovery_codes (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    code_hash character(64) NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    used_at timestamp with time zone
+);
+
 
+ALTER TABLE public.mfa_recovery_codes OWNER TO postgres;
 
 --
--- Name: file_hashes id; Type: DEFAULT; Schema: public; Owner: postgres
+-- Name: mfa_recovery_codes_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.file_hashes ALTER COLUMN id SET DEFAULT nextval('public.file_hashes_id_seq'::regclass);
+CREATE SEQUENCE public.mfa_recovery_codes_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
 
 
+ALTER SEQUENCE public.mfa_recovery_codes_id_seq OWNER TO postgres;
+
 --
--- Name: files id; Type: DEFAULT; Schema: public; Owner: postgres
+-- Name: mfa_recovery_codes_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.files ALTER COLUMN id SET DEFAULT nextval('public.files_id_seq'::regclass);
+ALTER SEQUENCE public.mfa_recovery_codes_id_seq OWNED BY public.mfa_recovery_codes.id;
 
 
 --
--- Name: shares id; Type: DEFAULT; Schema: public; Owner: postgres
+-- Name: oidc_logins; Type: TABLE; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.shares ALTER COLUMN id SET DEFAULT nextval('public.shares_id_seq'::regclass);
+CREATE TABLE public.oidc_logins (
+    state_hash character(64) NOT NULL,
+    nonce text NOT NULL,
+    code_verifier text NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    expires_at timestamp with time zone NOT NULL
+);
 
 
+ALTER TABLE public.oidc_logins OWNER TO postgres;
+
 --
--- Name: users id; Type: DEFAULT; Schema: public; Owner: postgres
+-- Name: refresh_tokens; Type: TABLE; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.users ALTER COLUMN id SET DEFAULT nextval('public.users_id_seq'::regclass);
+CREATE TABLE public.refresh_tokens (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    family_id character varying(64) NOT NULL,
+    token_hash character(64) NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone,
+    revoked_at timestamp with time zone,
+    user_agent text,
+    ip character varying(64)
+);
 
 
+ALTER TABLE public.refresh_tokens OWNER TO postgres;
+
 --
--- Name: downloads downloads_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: refresh_tokens_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.downloads
-    ADD CONSTRAINT downloads_pkey PRIMARY KEY (id);
+CREATE SEQUENCE public.refresh_tokens_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
 
 
+ALTER SEQUENCE public.refresh_tokens_id_seq OWNER TO postgres;
+
 --
--- Name: file_hashes file_hashes_hash_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: refresh_tokens_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.file_hashes
-    ADD CONSTRAINT file_hashes_hash_key UNIQUE (hash);
+ALTER SEQUENCE public.refresh_tokens_id_seq OWNED BY public.refresh_tokens.id;
 
 
 --
--- Name: file_hashes file_hashes_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: revoked_tokens; Type: TABLE; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.file_hashes
-    ADD CONSTRAINT file_hashes_pkey PRIMARY KEY (id);
+CREATE TABLE public.revoked_tokens (
+    jti character varying(64) NOT NULL,
+    user_id integer NOT NULL,
+    expires_at timestamp with time zone NOT NULL
+);
+
 
+ALTER TABLE public.revoked_tokens OWNER TO postgres;
 
 --
--- Name: files files_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: share_invites; Type: TABLE; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.files
-    ADD CONSTRAINT files_pkey PRIMARY KEY (id);
+CREATE TABLE public.share_invites (
+    id integer NOT NULL,
+    file_id integer NOT NULL,
+    invited_by integer NOT NULL,
+    email character varying(255) NOT NULL,
+    share_type character varying(20) DEFAULT 'read'::character varying NOT NULL,
+    created_at timestamp without time zone DEFAULT now() NOT NULL,
+    CONSTRAINT share_invites_share_type_check CHECK (((share_type)::text = ANY ((ARRAY['read'::character varying, 'comment'::character varying, 'write'::character varying, 'reshare'::character varying])::text[])))
+);
 
 
+ALTER TABLE public.share_invites OWNER TO postgres;
+
 --
--- Name: shares idx_unique_share; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: share_invites_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.shares
-    ADD CONSTRAINT idx_unique_share UNIQUE (file_id, shared_by, target_user);
+CREATE SEQUENCE public.share_invites_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
 
 
+ALTER SEQUENCE public.share_invites_id_seq OWNER TO postgres;
+
 --
--- Name: shares shares_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: share_invites_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.shares
-    ADD CONSTRAINT shares_pkey PRIMARY KEY (id);
+ALTER SEQUENCE public.share_invites_id_seq OWNED BY public.share_invites.id;
 
 
 --
--- Name: users unique_email; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: share_links; Type: TABLE; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.users
-    ADD CONSTRAINT unique_email UNIQUE (email);
+CREATE TABLE public.share_links (
+    id integer NOT NULL,
+    file_id integer NOT NULL,
+    created_by integer NOT NULL,
+    token_hash character(64) NOT NULL,
+    expires_at timestamp with time zone,
+    max_downloads integer,
+    download_count integer DEFAULT 0 NOT NULL,
+    bytes_served bigint DEFAULT 0 NOT NULL,
+    password_hash text,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    revoked_at timestamp with time zone
+);
+
 
+ALTER TABLE public.share_links OWNER TO postgres;
 
 --
--- Name: files unique_file_hash; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: share_links_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.files
-    ADD CONSTRAINT unique_file_hash UNIQUE (file_hash);
+CREATE SEQUENCE public.share_links_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
 
+ALTER SEQUENCE public.share_links_id_seq OWNER TO postgres;
 
 --
--- Name: files unique_filehash; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: share_links_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.files
-    ADD CONSTRAINT unique_filehash UNIQUE (file_hash);
+ALTER SEQUENCE public.share_links_id_seq OWNED BY public.share_links.id;
 
 
 --
--- Name: users unique_username; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: shares; Type: TABLE; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.users
-    ADD CONSTRAINT unique_username UNIQUE (username);
+CREATE TABLE public.shares (
+    id integer NOT NULL,
+    file_id integer,
+    shared_by integer,
+    share_type character varying(20) DEFAULT 'read'::character varying,
+    target_user integer,
+    created_at timestamp without time zone DEFAULT now(),
+    shared_at timestamp without time zone DEFAULT now(),
+    target_group integer,
+    CONSTRAINT shares_target_check CHECK (((target_user IS NULL) <> (target_group IS NULL))),
+    CONSTRAINT shares_share_type_check CHECK (((share_type)::text = ANY ((ARRAY['read'::character varying, 'comment'::character varying, 'write'::character varying, 'reshare'::character varying])::text[])))
+);
+
 
+ALTER TABLE public.shares OWNER TO postgres;
 
 --
--- Name: user_storage user_storage_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: shares_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.user_storage
-    ADD CONSTRAINT user_storage_pkey PRIMARY KEY (user_id);
+CREATE SEQUENCE public.shares_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.shares_id_seq OWNER TO postgres;
+
+--
+-- Name: shares_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.shares_id_seq OWNED BY public.shares.id;
+
+
+--
+-- Name: tus_uploads; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.tus_uploads (
+    id character varying(64) NOT NULL,
+    user_id integer NOT NULL,
+    filename character varying(255) NOT NULL,
+    upload_length bigint NOT NULL,
+    upload_offset bigint DEFAULT 0 NOT NULL,
+    metadata text,
+    created_at timestamp without time zone DEFAULT now(),
+    expires_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.tus_uploads OWNER TO postgres;
+
+--
+-- Name: user_identities; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.user_identities (
+    id integer NOT NULL,
+    user_id integer NOT NULL,
+    issuer text NOT NULL,
+    subject text NOT NULL,
+    email character varying(255),
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    last_login_at timestamp with time zone
+);
+
+
+ALTER TABLE public.user_identities OWNER TO postgres;
+
+--
+-- Name: user_identities_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.user_identities_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.user_identities_id_seq OWNER TO postgres;
+
+--
+-- Name: user_identities_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.user_identities_id_seq OWNED BY public.user_identities.id;
+
+
+--
+-- Name: user_storage; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.user_storage (
+    user_id integer NOT NULL,
+    used_space bigint DEFAULT 0,
+    original_space bigint DEFAULT 0,
+    used_bytes bigint DEFAULT 0 NOT NULL,
+    quota_bytes bigint DEFAULT 104857600 NOT NULL
+);
+
+
+ALTER TABLE public.user_storage OWNER TO postgres;
+
+--
+-- Name: users; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.users (
+    id integer NOT NULL,
+    username character varying(100) NOT NULL,
+    email character varying(255) NOT NULL,
+    password_hash text NOT NULL,
+    role character varying(20) DEFAULT 'user'::character varying,
+    created_at timestamp without time zone DEFAULT now(),
+    used_bytes bigint DEFAULT 0,
+    disabled_at timestamp without time zone,
+    sessions_revoked_at timestamp with time zone,
+    mfa_secret text,
+    mfa_enabled_at timestamp with time zone,
+    mfa_last_step bigint,
+    email_verified_at timestamp with time zone,
+    failed_logins integer DEFAULT 0 NOT NULL,
+    locked_until timestamp with time zone
+);
+
+
+ALTER TABLE public.users OWNER TO postgres;
+
+--
+-- Name: users_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+CREATE SEQUENCE public.users_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+ALTER SEQUENCE public.users_id_seq OWNER TO postgres;
+
+--
+-- Name: users_id_seq; Type: SEQUENCE OWNED BY; Schema: public; Owner: postgres
+--
+
+ALTER SEQUENCE public.users_id_seq OWNED BY public.users.id;
+
+
+--
+-- Name: audit_log id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.audit_log ALTER COLUMN id SET DEFAULT nextval('public.audit_log_id_seq'::regclass);
+
+
+--
+-- Name: downloads id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.downloads ALTER COLUMN id SET DEFAULT nextval('public.downloads_id_seq'::regclass);
+
+
+--
+-- Name: file_hashes id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.file_hashes ALTER COLUMN id SET DEFAULT nextval('public.file_hashes_id_seq'::regclass);
+
+
+--
+-- Name: file_versions id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.file_versions ALTER COLUMN id SET DEFAULT nextval('public.file_versions_id_seq'::regclass);
+
+
+--
+-- Name: files id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.files ALTER COLUMN id SET DEFAULT nextval('public.files_id_seq'::regclass);
+
+
+--
+-- Name: folders id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.folders ALTER COLUMN id SET DEFAULT nextval('public.folders_id_seq'::regclass);
+
+
+--
+-- Name: shares id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.shares ALTER COLUMN id SET DEFAULT nextval('public.shares_id_seq'::regclass);
+
+
+--
+-- Name: share_links id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.share_links ALTER COLUMN id SET DEFAULT nextval('public.share_links_id_seq'::regclass);
+
+
+--
+-- Name: share_invites id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.share_invites ALTER COLUMN id SET DEFAULT nextval('public.share_invites_id_seq'::regclass);
+
+
+--
+-- Name: groups id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.groups ALTER COLUMN id SET DEFAULT nextval('public.groups_id_seq'::regclass);
+
+
+--
+-- Name: refresh_tokens id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.refresh_tokens ALTER COLUMN id SET DEFAULT nextval('public.refresh_tokens_id_seq'::regclass);
+
+
+--
+-- Name: mfa_recovery_codes id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.mfa_recovery_codes ALTER COLUMN id SET DEFAULT nextval('public.mfa_recovery_codes_id_seq'::regclass);
+
+
+--
+-- Name: api_keys id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.api_keys ALTER COLUMN id SET DEFAULT nextval('public.api_keys_id_seq'::regclass);
+
+
+--
+-- Name: user_identities id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.user_identities ALTER COLUMN id SET DEFAULT nextval('public.user_identities_id_seq'::regclass);
+
+
+--
+-- Name: email_tokens id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.email_tokens ALTER COLUMN id SET DEFAULT nextval('public.email_tokens_id_seq'::regclass);
+
+
+--
+-- Name: users id; Type: DEFAULT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.users ALTER COLUMN id SET DEFAULT nextval('public.users_id_seq'::regclass);
+
+
+--
+-- Name: audit_log audit_log_hash_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.audit_log
+    ADD CONSTRAINT audit_log_hash_key UNIQUE (hash);
+
+
+--
+-- Name: audit_log audit_log_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.audit_log
+    ADD CONSTRAINT audit_log_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: downloads downloads_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.downloads
+    ADD CONSTRAINT downloads_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: file_hashes file_hashes_hash_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.file_hashes
+    ADD CONSTRAINT file_hashes_hash_key UNIQUE (hash);
+
+
+--
+-- Name: file_hashes file_hashes_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.file_hashes
+    ADD CONSTRAINT file_hashes_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: file_versions file_versions_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.file_versions
+    ADD CONSTRAINT file_versions_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: file_versions file_versions_file_id_version_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.file_versions
+    ADD CONSTRAINT file_versions_file_id_version_key UNIQUE (file_id, version);
+
+
+--
+-- Name: files files_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.files
+    ADD CONSTRAINT files_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: folders folders_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.folders
+    ADD CONSTRAINT folders_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: shares idx_unique_share; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.shares
+    ADD CONSTRAINT idx_unique_share UNIQUE (file_id, shared_by, target_user);
+
+
+--
+-- Name: shares shares_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.shares
+    ADD CONSTRAINT shares_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: users unique_email; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.users
+    ADD CONSTRAINT unique_email UNIQUE (email);
+
+
+--
+-- Name: users unique_username; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.users
+    ADD CONSTRAINT unique_username UNIQUE (username);
+
+
+--
+-- Name: tus_uploads tus_uploads_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.tus_uploads
+    ADD CONSTRAINT tus_uploads_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: user_storage user_storage_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.user_storage
+    ADD CONSTRAINT user_storage_pkey PRIMARY KEY (user_id);
 
 
 --
 -- Name: users users_email_key; Type: CONSTRAINT; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.users
-    ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE ONLY public.users
+    ADD CONSTRAINT users_email_key UNIQUE (email);
+
+
+--
+-- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.users
+    ADD CONSTRAINT users_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: users users_username_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.users
+    ADD CONSTRAINT users_username_key UNIQUE (username);
+
+
+--
+-- Name: share_links share_links_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.share_links
+    ADD CONSTRAINT share_links_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: share_links share_links_token_hash_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.share_links
+    ADD CONSTRAINT share_links_token_hash_key UNIQUE (token_hash);
+
+
+--
+-- Name: share_invites share_invites_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.share_invites
+    ADD CONSTRAINT share_invites_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: share_invites share_invites_file_id_invited_by_email_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.share_invites
+    ADD CONSTRAINT share_invites_file_id_invited_by_email_key UNIQUE (file_id, invited_by, email);
+
+
+--
+-- Name: group_members group_members_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.group_members
+    ADD CONSTRAINT group_members_pkey PRIMARY KEY (group_id, user_id);
+
+
+--
+-- Name: groups groups_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.groups
+    ADD CONSTRAINT groups_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: refresh_tokens refresh_tokens_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.refresh_tokens
+    ADD CONSTRAINT refresh_tokens_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: refresh_tokens refresh_tokens_token_hash_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.refresh_tokens
+    ADD CONSTRAINT refresh_tokens_token_hash_key UNIQUE (token_hash);
+
+
+--
+-- Name: revoked_tokens revoked_tokens_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.revoked_tokens
+    ADD CONSTRAINT revoked_tokens_pkey PRIMARY KEY (jti);
+
+
+--
+-- Name: mfa_recovery_codes mfa_recovery_codes_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.mfa_recovery_codes
+    ADD CONSTRAINT mfa_recovery_codes_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: mfa_recovery_codes mfa_recovery_codes_user_id_code_hash_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.mfa_recovery_codes
+    ADD CONSTRAINT mfa_recovery_codes_user_id_code_hash_key UNIQUE (user_id, code_hash);
+
+
+--
+-- Name: api_keys api_keys_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.api_keys
+    ADD CONSTRAINT api_keys_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: api_keys api_keys_key_hash_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.api_keys
+    ADD CONSTRAINT api_keys_key_hash_key UNIQUE (key_hash);
 
 
 --
--- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: oidc_logins oidc_logins_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.users
-    ADD CONSTRAINT users_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY public.oidc_logins
+    ADD CONSTRAINT oidc_logins_pkey PRIMARY KEY (state_hash);
 
 
 --
--- Name: users users_username_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+-- Name: user_identities user_identities_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
 --
 
-ALTER TABLE ONLY public.users
-    ADD CONSTRAINT users_username_key UNIQUE (username);
+ALTER TABLE ONLY public.user_identities
+    ADD CONSTRAINT user_identities_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: user_identities user_identities_issuer_subject_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.user_identities
+    ADD CONSTRAINT user_identities_issuer_subject_key UNIQUE (issuer, subject);
+
+
+--
+-- Name: email_tokens email_tokens_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.email_tokens
+    ADD CONSTRAINT email_tokens_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: email_tokens email_tokens_token_hash_key; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.email_tokens
+    ADD CONSTRAINT email_tokens_token_hash_key UNIQUE (token_hash);
+
+
+--
+-- Name: idx_files_user_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_files_user_id ON public.files USING btree (user_id);
+
+
+--
+-- Name: idx_files_file_hash_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_files_file_hash_id ON public.files USING btree (file_hash_id);
+
+
+--
+-- Name: idx_folders_unique_name; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX idx_folders_unique_name ON public.folders USING btree (user_id, COALESCE(parent_id, 0), name);
+
+
+--
+-- Name: idx_files_folder_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_files_folder_id ON public.files USING btree (folder_id);
+
+
+--
+-- Name: idx_files_trash; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_files_trash ON public.files USING btree (deleted_at) WHERE is_deleted;
+
+
+--
+-- Name: idx_share_links_file_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_share_links_file_id ON public.share_links USING btree (file_id);
+
+
+--
+-- Name: idx_share_invites_email; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_share_invites_email ON public.share_invites USING btree (email);
+
+
+--
+-- Name: idx_group_members_user_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_group_members_user_id ON public.group_members USING btree (user_id);
+
+
+--
+-- Name: idx_unique_group_share; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX idx_unique_group_share ON public.shares USING btree (file_id, shared_by, target_group) WHERE (target_group IS NOT NULL);
+
+
+--
+-- Name: idx_shares_target_group; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_shares_target_group ON public.shares USING btree (target_group);
+
+
+--
+-- Name: idx_downloads_file_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_downloads_file_id ON public.downloads USING btree (file_id, downloaded_at);
+
+
+--
+-- Name: idx_audit_log_actor; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_audit_log_actor ON public.audit_log USING btree (actor_id, id);
+
+
+--
+-- Name: idx_audit_log_action; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_audit_log_action ON public.audit_log USING btree (action, id);
+
+
+--
+-- Name: audit_log audit_log_no_update; Type: TRIGGER; Schema: public; Owner: postgres
+--
+
+CREATE TRIGGER audit_log_no_update BEFORE DELETE OR UPDATE ON public.audit_log FOR EACH ROW EXECUTE FUNCTION public.audit_log_append_only();
+
+
+--
+-- Name: audit_log audit_log_no_truncate; Type: TRIGGER; Schema: public; Owner: postgres
+--
+
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON public.audit_log FOR EACH STATEMENT EXECUTE FUNCTION public.audit_log_append_only();
+
+
+--
+-- Name: idx_refresh_tokens_family; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_refresh_tokens_family ON public.refresh_tokens USING btree (family_id);
+
+
+--
+-- Name: idx_refresh_tokens_user_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_refresh_tokens_user_id ON public.refresh_tokens USING btree (user_id);
+
+
+--
+-- Name: idx_revoked_tokens_expires_at; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_revoked_tokens_expires_at ON public.revoked_tokens USING btree (expires_at);
+
+
+--
+-- Name: idx_api_keys_user_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_api_keys_user_id ON public.api_keys USING btree (user_id);
+
+
+--
+-- Name: idx_user_identities_user_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_user_identities_user_id ON public.user_identities USING btree (user_id);
+
+
+--
+-- Name: idx_email_tokens_user_id; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX idx_email_tokens_user_id ON public.email_tokens USING btree (user_id, purpose);
 
 
 --
@@ -384,7 +1318,55 @@ ALTER TABLE ONLY public.users
 --
 
 ALTER TABLE ONLY public.downloads
-    ADD CONSTRAINT downloads_file_id_fkey FOREIGN KEY (file_id) REFERENCES public.files(id) ON DELETE CASCADE;
+    ADD CONSTRAINT downloads_file_id_fkey FOREIGN KEY (file_id) REFERENCES public.files(id) ON DELETE SET NULL;
+
+
+--
+-- Name: downloads downloads_link_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.downloads
+    ADD CONSTRAINT downloads_link_id_fkey FOREIGN KEY (link_id) REFERENCES public.share_links(id) ON DELETE SET NULL;
+
+
+--
+-- Name: downloads downloads_owner_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.downloads
+    ADD CONSTRAINT downloads_owner_id_fkey FOREIGN KEY (owner_id) REFERENCES public.users(id) ON DELETE SET NULL;
+
+
+--
+-- Name: downloads downloads_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.downloads
+    ADD CONSTRAINT downloads_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE SET NULL;
+
+
+--
+-- Name: file_versions file_versions_file_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.file_versions
+    ADD CONSTRAINT file_versions_file_id_fkey FOREIGN KEY (file_id) REFERENCES public.files(id) ON DELETE CASCADE;
+
+
+--
+-- Name: file_versions file_versions_file_hash_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.file_versions
+    ADD CONSTRAINT file_versions_file_hash_id_fkey FOREIGN KEY (file_hash_id) REFERENCES public.file_hashes(id);
+
+
+--
+-- Name: file_versions file_versions_created_by_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.file_versions
+    ADD CONSTRAINT file_versions_created_by_fkey FOREIGN KEY (created_by) REFERENCES public.users(id) ON DELETE SET NULL;
 
 
 --
@@ -395,6 +1377,14 @@ ALTER TABLE ONLY public.files
     ADD CONSTRAINT files_file_hash_id_fkey FOREIGN KEY (file_hash_id) REFERENCES public.file_hashes(id) ON DELETE CASCADE;
 
 
+--
+-- Name: files files_folder_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.files
+    ADD CONSTRAINT files_folder_id_fkey FOREIGN KEY (folder_id) REFERENCES public.folders(id) ON DELETE SET NULL;
+
+
 --
 -- Name: files files_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -403,6 +1393,22 @@ ALTER TABLE ONLY public.files
     ADD CONSTRAINT files_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
 
 
+--
+-- Name: folders folders_parent_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.folders
+    ADD CONSTRAINT folders_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES public.folders(id) ON DELETE CASCADE;
+
+
+--
+-- Name: folders folders_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.folders
+    ADD CONSTRAINT folders_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
 --
 -- Name: shares shares_file_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -419,6 +1425,14 @@ ALTER TABLE ONLY public.shares
     ADD CONSTRAINT shares_shared_by_fkey FOREIGN KEY (shared_by) REFERENCES public.users(id);
 
 
+--
+-- Name: shares shares_target_group_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.shares
+    ADD CONSTRAINT shares_target_group_fkey FOREIGN KEY (target_group) REFERENCES public.groups(id) ON DELETE CASCADE;
+
+
 --
 -- Name: shares shares_target_user_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -427,6 +1441,14 @@ ALTER TABLE ONLY public.shares
     ADD CONSTRAINT shares_target_user_fkey FOREIGN KEY (target_user) REFERENCES public.users(id);
 
 
+--
+-- Name: tus_uploads tus_uploads_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.tus_uploads
+    ADD CONSTRAINT tus_uploads_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
 --
 -- Name: user_storage user_storage_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -435,6 +1457,110 @@ ALTER TABLE ONLY public.user_storage
     ADD CONSTRAINT user_storage_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
 
 
+--
+-- Name: share_links share_links_file_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.share_links
+    ADD CONSTRAINT share_links_file_id_fkey FOREIGN KEY (file_id) REFERENCES public.files(id) ON DELETE CASCADE;
+
+
+--
+-- Name: share_links share_links_created_by_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.share_links
+    ADD CONSTRAINT share_links_created_by_fkey FOREIGN KEY (created_by) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
+--
+-- Name: share_invites share_invites_file_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.share_invites
+    ADD CONSTRAINT share_invites_file_id_fkey FOREIGN KEY (file_id) REFERENCES public.files(id) ON DELETE CASCADE;
+
+
+--
+-- Name: share_invites share_invites_invited_by_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.share_invites
+    ADD CONSTRAINT share_invites_invited_by_fkey FOREIGN KEY (invited_by) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
+--
+-- Name: group_members group_members_group_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.group_members
+    ADD CONSTRAINT group_members_group_id_fkey FOREIGN KEY (group_id) REFERENCES public.groups(id) ON DELETE CASCADE;
+
+
+--
+-- Name: group_members group_members_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.group_members
+    ADD CONSTRAINT group_members_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
+--
+-- Name: groups groups_created_by_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.groups
+    ADD CONSTRAINT groups_created_by_fkey FOREIGN KEY (created_by) REFERENCES public.users(id) ON DELETE SET NULL;
+
+
+--
+-- Name: refresh_tokens refresh_tokens_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.refresh_tokens
+    ADD CONSTRAINT refresh_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
+--
+-- Name: revoked_tokens revoked_tokens_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.revoked_tokens
+    ADD CONSTRAINT revoked_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
+--
+-- Name: mfa_recovery_codes mfa_recovery_codes_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.mfa_recovery_codes
+    ADD CONSTRAINT mfa_recovery_codes_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
+--
+-- Name: api_keys api_keys_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.api_keys
+    ADD CONSTRAINT api_keys_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
+--
+-- Name: user_identities user_identities_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.user_identities
+    ADD CONSTRAINT user_identities_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
+--
+-- Name: email_tokens email_tokens_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.email_tokens
+    ADD CONSTRAINT email_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+
 --
 -- PostgreSQL database dump complete
 --
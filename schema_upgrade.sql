@@ -0,0 +1,327 @@
+--
+-- Upgrades a database created from the original schema.sql to the current
+-- one. Every step checks before it changes anything, so the script can be
+-- re-run safely, and it runs in one transaction so a failure leaves the
+-- database as it was.
+--
+--   psql -d filevault -f schema_upgrade.sql
+--
+-- Files uploaded before content-addressed storage have no file_hash_id and
+-- keep their blob under the old "uploads/" path. They are left in place and
+-- served from there; the first new version of such a file adopts the old
+-- content as version 1.
+--
+
+BEGIN;
+
+CREATE FUNCTION pg_temp.add_constraint(tbl regclass, name text, def text) RETURNS void
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = tbl AND conname = name) THEN
+        EXECUTE format('ALTER TABLE %s ADD CONSTRAINT %I %s', tbl, name, def);
+    END IF;
+END;
+$$;
+
+--
+-- users
+--
+
+ALTER TABLE public.users
+    ADD COLUMN IF NOT EXISTS disabled_at timestamp without time zone,
+    ADD COLUMN IF NOT EXISTS sessions_revoked_at timestamp with time zone,
+    ADD COLUMN IF NOT EXISTS mfa_secret text,
+    ADD COLUMN IF NOT EXISTS mfa_enabled_at timestamp with time zone,
+    ADD COLUMN IF NOT EXISTS mfa_last_step bigint,
+    ADD COLUMN IF NOT EXISTS email_verified_at timestamp with time zone,
+    ADD COLUMN IF NOT EXISTS failed_logins integer DEFAULT 0 NOT NULL,
+    ADD COLUMN IF NOT EXISTS locked_until timestamp with time zone;
+
+--
+-- folders, groups
+--
+
+CREATE TABLE IF NOT EXISTS public.folders (
+    id serial CONSTRAINT folders_pkey PRIMARY KEY,
+    user_id integer NOT NULL CONSTRAINT folders_user_id_fkey REFERENCES public.users(id) ON DELETE CASCADE,
+    parent_id integer CONSTRAINT folders_parent_id_fkey REFERENCES public.folders(id) ON DELETE CASCADE,
+    name character varying(255) NOT NULL,
+    created_at timestamp without time zone DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_folders_unique_name ON public.folders USING btree (user_id, COALESCE(parent_id, 0), name);
+
+CREATE TABLE IF NOT EXISTS public.groups (
+    id serial CONSTRAINT groups_pkey PRIMARY KEY,
+    name character varying(100) NOT NULL,
+    created_by integer CONSTRAINT groups_created_by_fkey REFERENCES public.users(id) ON DELETE SET NULL,
+    created_at timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.group_members (
+    group_id integer NOT NULL CONSTRAINT group_members_group_id_fkey REFERENCES public.groups(id) ON DELETE CASCADE,
+    user_id integer NOT NULL CONSTRAINT group_members_user_id_fkey REFERENCES public.users(id) ON DELETE CASCADE,
+    is_admin boolean DEFAULT false NOT NULL,
+    added_at timestamp without time zone DEFAULT now() NOT NULL,
+    CONSTRAINT group_members_pkey PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_members_user_id ON public.group_members USING btree (user_id);
+
+--
+-- files: one row per user upload instead of one per distinct hash
+--
+
+ALTER TABLE public.files DROP CONSTRAINT IF EXISTS unique_file_hash;
+ALTER TABLE public.files DROP CONSTRAINT IF EXISTS unique_filehash;
+
+ALTER TABLE public.files
+    ADD COLUMN IF NOT EXISTS folder_id integer,
+    ADD COLUMN IF NOT EXISTS deleted_at timestamp without time zone,
+    ADD COLUMN IF NOT EXISTS total_size bigint,
+    ADD COLUMN IF NOT EXISTS current_version integer,
+    ADD COLUMN IF NOT EXISTS updated_at timestamp without time zone,
+    DROP COLUMN IF EXISTS ref_count;
+
+UPDATE public.files SET is_deleted = false WHERE is_deleted IS NULL;
+UPDATE public.files SET deleted_at = COALESCE(uploaded_at, now()) WHERE is_deleted AND deleted_at IS NULL;
+UPDATE public.files SET total_size = COALESCE(size, 0) WHERE total_size IS NULL;
+
+ALTER TABLE public.files ALTER COLUMN is_deleted SET NOT NULL;
+
+SELECT pg_temp.add_constraint('public.files', 'files_folder_id_fkey',
+    'FOREIGN KEY (folder_id) REFERENCES public.folders(id) ON DELETE SET NULL');
+
+CREATE INDEX IF NOT EXISTS idx_files_user_id ON public.files USING btree (user_id);
+CREATE INDEX IF NOT EXISTS idx_files_file_hash_id ON public.files USING btree (file_hash_id);
+CREATE INDEX IF NOT EXISTS idx_files_folder_id ON public.files USING btree (folder_id);
+CREATE INDEX IF NOT EXISTS idx_files_trash ON public.files USING btree (deleted_at) WHERE is_deleted;
+
+CREATE TABLE IF NOT EXISTS public.file_versions (
+    id serial CONSTRAINT file_versions_pkey PRIMARY KEY,
+    file_id integer NOT NULL CONSTRAINT file_versions_file_id_fkey REFERENCES public.files(id) ON DELETE CASCADE,
+    version integer NOT NULL,
+    file_hash_id integer CONSTRAINT file_versions_file_hash_id_fkey REFERENCES public.file_hashes(id),
+    file_hash text NOT NULL,
+    size bigint NOT NULL,
+    mime_type character varying(100),
+    created_by integer CONSTRAINT file_versions_created_by_fkey REFERENCES public.users(id) ON DELETE SET NULL,
+    created_at timestamp without time zone DEFAULT now(),
+    CONSTRAINT file_versions_file_id_version_key UNIQUE (file_id, version)
+);
+
+CREATE TABLE IF NOT EXISTS public.tus_uploads (
+    id character varying(64) CONSTRAINT tus_uploads_pkey PRIMARY KEY,
+    user_id integer NOT NULL CONSTRAINT tus_uploads_user_id_fkey REFERENCES public.users(id) ON DELETE CASCADE,
+    filename character varying(255) NOT NULL,
+    upload_length bigint NOT NULL,
+    upload_offset bigint DEFAULT 0 NOT NULL,
+    metadata text,
+    created_at timestamp without time zone DEFAULT now(),
+    expires_at timestamp without time zone NOT NULL
+);
+
+--
+-- sharing
+--
+
+ALTER TABLE public.shares ADD COLUMN IF NOT EXISTS target_group integer;
+
+-- The old handler stored whatever share_type it was sent
+UPDATE public.shares SET share_type = 'read'
+    WHERE share_type IS NULL OR share_type NOT IN ('read', 'comment', 'write', 'reshare');
+DELETE FROM public.shares WHERE target_user IS NULL AND target_group IS NULL;
+
+SELECT pg_temp.add_constraint('public.shares', 'shares_target_check',
+    'CHECK (((target_user IS NULL) <> (target_group IS NULL)))');
+SELECT pg_temp.add_constraint('public.shares', 'shares_share_type_check',
+    $$CHECK (((share_type)::text = ANY ((ARRAY['read'::character varying, 'comment'::character varying, 'write'::character varying, 'reshare'::character varying])::text[])))$$);
+SELECT pg_temp.add_constraint('public.shares', 'shares_target_group_fkey',
+    'FOREIGN KEY (target_group) REFERENCES public.groups(id) ON DELETE CASCADE');
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_unique_group_share ON public.shares USING btree (file_id, shared_by, target_group) WHERE (target_group IS NOT NULL);
+CREATE INDEX IF NOT EXISTS idx_shares_target_group ON public.shares USING btree (target_group);
+
+CREATE TABLE IF NOT EXISTS public.share_links (
+    id serial CONSTRAINT share_links_pkey PRIMARY KEY,
+    file_id integer NOT NULL CONSTRAINT share_links_file_id_fkey REFERENCES public.files(id) ON DELETE CASCADE,
+    created_by integer NOT NULL CONSTRAINT share_links_created_by_fkey REFERENCES public.users(id) ON DELETE CASCADE,
+    token_hash character(64) NOT NULL CONSTRAINT share_links_token_hash_key UNIQUE,
+    expires_at timestamp with time zone,
+    max_downloads integer,
+    download_count integer DEFAULT 0 NOT NULL,
+    bytes_served bigint DEFAULT 0 NOT NULL,
+    password_hash text,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    revoked_at timestamp with time zone
+);
+
+ALTER TABLE public.share_links ADD COLUMN IF NOT EXISTS bytes_served bigint DEFAULT 0 NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_share_links_file_id ON public.share_links USING btree (file_id);
+
+CREATE TABLE IF NOT EXISTS public.share_invites (
+    id serial CONSTRAINT share_invites_pkey PRIMARY KEY,
+    file_id integer NOT NULL CONSTRAINT share_invites_file_id_fkey REFERENCES public.files(id) ON DELETE CASCADE,
+    invited_by integer NOT NULL CONSTRAINT share_invites_invited_by_fkey REFERENCES public.users(id) ON DELETE CASCADE,
+    email character varying(255) NOT NULL,
+    share_type character varying(20) DEFAULT 'read'::character varying NOT NULL,
+    created_at timestamp without time zone DEFAULT now() NOT NULL,
+    CONSTRAINT share_invites_share_type_check CHECK (((share_type)::text = ANY ((ARRAY['read'::character varying, 'comment'::character varying, 'write'::character varying, 'reshare'::character varying])::text[]))),
+    CONSTRAINT share_invites_file_id_invited_by_email_key UNIQUE (file_id, invited_by, email)
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_invites_email ON public.share_invites USING btree (email);
+
+--
+-- downloads
+--
+
+ALTER TABLE public.downloads
+    ADD COLUMN IF NOT EXISTS user_id integer,
+    ADD COLUMN IF NOT EXISTS link_id integer,
+    ADD COLUMN IF NOT EXISTS version integer,
+    ADD COLUMN IF NOT EXISTS user_agent text,
+    ADD COLUMN IF NOT EXISTS bytes_served bigint DEFAULT 0 NOT NULL,
+    ADD COLUMN IF NOT EXISTS status smallint DEFAULT 200 NOT NULL,
+    ADD COLUMN IF NOT EXISTS filename character varying(255),
+    ADD COLUMN IF NOT EXISTS owner_id integer;
+
+-- Keep the trail when a file is purged: rows remember the file's name and
+-- owner, and only lose the link to the files row
+UPDATE public.downloads d SET filename = f.filename, owner_id = f.user_id
+    FROM public.files f WHERE f.id = d.file_id AND d.filename IS NULL;
+
+ALTER TABLE public.downloads DROP CONSTRAINT IF EXISTS downloads_file_id_fkey;
+ALTER TABLE public.downloads ADD CONSTRAINT downloads_file_id_fkey
+    FOREIGN KEY (file_id) REFERENCES public.files(id) ON DELETE SET NULL;
+
+SELECT pg_temp.add_constraint('public.downloads', 'downloads_user_id_fkey',
+    'FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE SET NULL');
+SELECT pg_temp.add_constraint('public.downloads', 'downloads_link_id_fkey',
+    'FOREIGN KEY (link_id) REFERENCES public.share_links(id) ON DELETE SET NULL');
+SELECT pg_temp.add_constraint('public.downloads', 'downloads_owner_id_fkey',
+    'FOREIGN KEY (owner_id) REFERENCES public.users(id) ON DELETE SET NULL');
+
+CREATE INDEX IF NOT EXISTS idx_downloads_file_id ON public.downloads USING btree (file_id, downloaded_at);
+
+--
+-- audit log
+--
+
+CREATE TABLE IF NOT EXISTS public.audit_log (
+    id bigserial CONSTRAINT audit_log_pkey PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL,
+    actor_id integer,
+    action character varying(64) NOT NULL,
+    target_type character varying(32) DEFAULT ''::character varying NOT NULL,
+    target_id character varying(64) DEFAULT ''::character varying NOT NULL,
+    ip character varying(45) DEFAULT ''::character varying NOT NULL,
+    details text DEFAULT '{}'::text NOT NULL,
+    prev_hash character(64) NOT NULL,
+    hash character(64) NOT NULL CONSTRAINT audit_log_hash_key UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON public.audit_log USING btree (actor_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON public.audit_log USING btree (action, id);
+
+CREATE OR REPLACE FUNCTION public.audit_log_append_only() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$;
+
+DROP TRIGGER IF EXISTS audit_log_no_update ON public.audit_log;
+CREATE TRIGGER audit_log_no_update BEFORE DELETE OR UPDATE ON public.audit_log FOR EACH ROW EXECUTE FUNCTION public.audit_log_append_only();
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON public.audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON public.audit_log FOR EACH STATEMENT EXECUTE FUNCTION public.audit_log_append_only();
+
+--
+-- sessions, API keys, MFA, SSO, email tokens
+--
+
+CREATE TABLE IF NOT EXISTS public.refresh_tokens (
+    id serial CONSTRAINT refresh_tokens_pkey PRIMARY KEY,
+    user_id integer NOT NULL CONSTRAINT refresh_tokens_user_id_fkey REFERENCES public.users(id) ON DELETE CASCADE,
+    family_id character varying(64) NOT NULL,
+    token_hash character(64) NOT NULL CONSTRAINT refresh_tokens_token_hash_key UNIQUE,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone,
+    revoked_at timestamp with time zone,
+    user_agent text,
+    ip character varying(64)
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON public.refresh_tokens USING btree (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON public.refresh_tokens USING btree (user_id);
+
+CREATE TABLE IF NOT EXISTS public.revoked_tokens (
+    jti character varying(64) CONSTRAINT revoked_tokens_pkey PRIMARY KEY,
+    user_id integer NOT NULL CONSTRAINT revoked_tokens_user_id_fkey REFERENCES public.users(id) ON DELETE CASCADE,
+    expires_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON public.revoked_tokens USING btree (expires_at);
+
+CREATE TABLE IF NOT EXISTS public.api_keys (
+    id serial CONSTRAINT api_keys_pkey PRIMARY KEY,
+    user_id integer NOT NULL CONSTRAINT api_keys_user_id_fkey REFERENCES public.users(id) ON DELETE CASCADE,
+    name character varying(100) NOT NULL,
+    prefix character varying(16) NOT NULL,
+    key_hash character(64) NOT NULL CONSTRAINT api_keys_key_hash_key UNIQUE,
+    scopes text[] NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    expires_at timestamp with time zone,
+    last_used_at timestamp with time zone,
+    revoked_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON public.api_keys USING btree (user_id);
+
+CREATE TABLE IF NOT EXISTS public.mfa_recovery_codes (
+    id serial CONSTRAINT mfa_recovery_codes_pkey PRIMARY KEY,
+    user_id integer NOT NULL CONSTRAINT mfa_recovery_codes_user_id_fkey REFERENCES public.users(id) ON DELETE CASCADE,
+    code_hash character(64) NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    used_at timestamp with time zone,
+    CONSTRAINT mfa_recovery_codes_user_id_code_hash_key UNIQUE (user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS public.oidc_logins (
+    state_hash character(64) CONSTRAINT oidc_logins_pkey PRIMARY KEY,
+    nonce text NOT NULL,
+    code_verifier text NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    expires_at timestamp with time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.user_identities (
+    id serial CONSTRAINT user_identities_pkey PRIMARY KEY,
+    user_id integer NOT NULL CONSTRAINT user_identities_user_id_fkey REFERENCES public.users(id) ON DELETE CASCADE,
+    issuer text NOT NULL,
+    subject text NOT NULL,
+    email character varying(255),
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    last_login_at timestamp with time zone,
+    CONSTRAINT user_identities_issuer_subject_key UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON public.user_identities USING btree (user_id);
+
+CREATE TABLE IF NOT EXISTS public.email_tokens (
+    id serial CONSTRAINT email_tokens_pkey PRIMARY KEY,
+    user_id integer NOT NULL CONSTRAINT email_tokens_user_id_fkey REFERENCES public.users(id) ON DELETE CASCADE,
+    purpose character varying(20) NOT NULL,
+    token_hash character(64) NOT NULL CONSTRAINT email_tokens_token_hash_key UNIQUE,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_tokens_user_id ON public.email_tokens USING btree (user_id, purpose);
+
+COMMIT;